@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flanksource/batch-runner/pkg"
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/duty/context"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	auditDB        string
+	auditBody      bool
+	auditRetention time.Duration
+
+	auditCmdDB  string
+	auditQuery  pkg.AuditQuery
+	auditSince  time.Duration
+	auditOutput string
+	auditBefore time.Duration
+)
+
+// BindAuditFlags adds the flags that enable the audit log of consumers
+func BindAuditFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&auditDB, "audit-db", "", "Record each message in an audit database, sqlite://<path> or postgres://")
+	flags.BoolVar(&auditBody, "audit-body", false, "Store the full body of messages in the audit database, otherwise only their hash and size")
+	flags.DurationVar(&auditRetention, "audit-retention", 30*24*time.Hour, "Delete audit records older than this, 0 keeps records forever")
+}
+
+// StartAudit opens the audit database if one is configured, and deletes old records in the background
+func StartAudit(ctx context.Context) {
+	if auditDB == "" {
+		return
+	}
+	store, err := pkg.OpenAuditStore(auditDB)
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+	store.Body = auditBody
+	pkg.SetAuditStore(store)
+	if auditRetention > 0 {
+		go store.RunRetention(ctx, auditRetention)
+	}
+}
+
+var AuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Query and prune the audit log of received messages",
+}
+
+var auditQueryCmd = &cobra.Command{
+	Use:   "query --db sqlite://audit.db",
+	Short: "List the messages that were received, most recent first",
+	Args:  cobra.NoArgs,
+	Run:   runAuditQuery,
+}
+
+var auditPruneCmd = &cobra.Command{
+	Use:   "prune --db sqlite://audit.db --older-than 720h",
+	Short: "Delete the records of messages received before a time",
+	Args:  cobra.NoArgs,
+	Run:   runAuditPrune,
+}
+
+func init() {
+	AuditCmd.PersistentFlags().StringVar(&auditCmdDB, "db", "", "Audit database, sqlite://<path> or postgres://")
+	_ = AuditCmd.MarkPersistentFlagRequired("db")
+
+	auditQueryCmd.Flags().StringVar(&auditQuery.Trigger, "trigger", "", "Only show messages of a trigger, namespace/name or the queue when running without the controller")
+	auditQueryCmd.Flags().StringVar(&auditQuery.MessageID, "message-id", "", "Only show deliveries of a message")
+	auditQueryCmd.Flags().StringVar(&auditQuery.Outcome, "outcome", "", "Only show messages that were processed, failed or retried")
+	auditQueryCmd.Flags().DurationVar(&auditSince, "since", 0, "Only show messages received in this duration, e.g. 24h")
+	auditQueryCmd.Flags().IntVar(&auditQuery.Limit, "limit", 100, "Maximum number of records, 0 for all")
+	auditQueryCmd.Flags().StringVarP(&auditOutput, "output", "o", "table", "Output format, table or json")
+
+	auditPruneCmd.Flags().DurationVar(&auditBefore, "older-than", 30*24*time.Hour, "Delete records of messages received longer ago than this")
+
+	AuditCmd.AddCommand(auditQueryCmd, auditPruneCmd)
+}
+
+func openAudit() *pkg.AuditStore {
+	store, err := pkg.OpenAuditStore(auditCmdDB)
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+	return store
+}
+
+func runAuditQuery(cmd *cobra.Command, args []string) {
+	ctx := context.New()
+	store := openAudit()
+	defer store.Close()
+
+	if auditSince > 0 {
+		auditQuery.Since = time.Now().Add(-auditSince)
+	}
+	records, err := store.Query(ctx, auditQuery)
+	if err != nil {
+		logger.Fatalf("error querying audit records: %v", err)
+		os.Exit(1)
+	}
+
+	if auditOutput == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				logger.Fatalf(err.Error())
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RECEIVED\tTRIGGER\tMESSAGE\tATTEMPT\tOUTCOME\tREASON\tDURATION\tOBJECT\tERROR")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			r.ReceivedAt.Local().Format(time.DateTime), r.Trigger, r.MessageID, r.Attempt, r.Outcome, r.Reason,
+			time.Duration(r.DurationMS)*time.Millisecond, r.Object, truncate(firstLine(r.Error), 80))
+	}
+	_ = w.Flush()
+}
+
+func runAuditPrune(cmd *cobra.Command, args []string) {
+	ctx := context.New()
+	store := openAudit()
+	defer store.Close()
+
+	deleted, err := store.Prune(ctx, time.Now().Add(-auditBefore))
+	if err != nil {
+		logger.Fatalf("error pruning audit records: %v", err)
+		os.Exit(1)
+	}
+	logger.Infof("Deleted %d audit records", deleted)
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
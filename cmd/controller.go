@@ -7,7 +7,10 @@ import (
 	"github.com/flanksource/commons/logger"
 	"github.com/flanksource/duty/context"
 	"github.com/spf13/cobra"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -31,18 +34,30 @@ func init() {
 	ControllerCmd.Flags().BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	BindAuditFlags(ControllerCmd.Flags())
+	BindCaptureFlags(ControllerCmd.Flags())
+	BindTracingFlags(ControllerCmd.Flags())
 }
 
 func runController(cmd *cobra.Command, args []string) {
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
 	dutyCtx := context.New()
+	StartAudit(dutyCtx)
+	StartCapture()
+	defer StartTracing()()
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: controller.GetScheme(),
 		Metrics: metricsserver.Options{
 			BindAddress: metricsAddr,
 		},
+		// Pods and Jobs are only listed for clean up, avoid caching every workload in the cluster
+		Client: client.Options{
+			Cache: &client.CacheOptions{
+				DisableFor: []client.Object{&corev1.Pod{}, &batchv1.Job{}},
+			},
+		},
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "batch-runner.flanksource.com",
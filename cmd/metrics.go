@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/pflag"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// runMetricsAddr is the metrics endpoint of consumers run without the controller, which serves its own endpoint
+var runMetricsAddr string
+
+// BindMetricsFlags adds the flag of the metrics endpoint of consumers run without the controller, which is
+// disabled by default
+func BindMetricsFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&runMetricsAddr, "metrics-bind-address", "0", "The address the metric endpoint binds to, e.g. :8080, 0 disables it.")
+}
+
+// StartMetrics serves the batch-runner metrics on /metrics in the background
+func StartMetrics() {
+	if runMetricsAddr == "" || runMetricsAddr == "0" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: runMetricsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		logger.Infof("Serving metrics on %s/metrics", runMetricsAddr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Error serving metrics: %v", err)
+		}
+	}()
+}
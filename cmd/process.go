@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/flanksource/batch-runner/pkg"
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/duty/context"
+	"github.com/spf13/cobra"
+)
+
+var (
+	processInput       string
+	processOutput      string
+	processConcurrency int
+	processDryRun      bool
+)
+
+var ProcessCmd = &cobra.Command{
+	Use:   "process config.yaml --input messages.jsonl",
+	Short: "Run the action of a config for each line of a file of messages, without a queue",
+	Args:  cobra.ExactArgs(1),
+	Run:   runProcess,
+}
+
+func init() {
+	ProcessCmd.Flags().StringVarP(&processInput, "input", "i", "", "Path to a file with a message body on each line, or - for stdin")
+	ProcessCmd.Flags().StringVarP(&processOutput, "output", "o", "results.jsonl", "Path to write the JSONL report to, or - for stdout")
+	ProcessCmd.Flags().IntVar(&processConcurrency, "concurrency", 1, "Number of messages to process at the same time")
+	ProcessCmd.Flags().BoolVar(&processDryRun, "dry-run", false, "Render each message without running the action")
+	_ = ProcessCmd.MarkFlagRequired("input")
+}
+
+func runProcess(cmd *cobra.Command, args []string) {
+	ctx := context.New()
+
+	configs, err := pkg.ParseConfigFiles(args)
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+	if len(configs) != 1 {
+		logger.Fatalf("%s has %d configs, process requires a single config", args[0], len(configs))
+		os.Exit(1)
+	}
+
+	var input io.Reader = os.Stdin
+	source := "stdin"
+	if processInput != "-" {
+		f, err := os.Open(processInput)
+		if err != nil {
+			logger.Fatalf("error opening %s: %v", processInput, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		input = f
+		source = filepath.Base(processInput)
+	}
+
+	var report io.Writer = os.Stdout
+	if processOutput != "-" {
+		f, err := os.Create(processOutput)
+		if err != nil {
+			logger.Fatalf("error creating %s: %v", processOutput, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		report = f
+	}
+
+	failed, err := pkg.ProcessMessages(ctx, &configs[0], input, report, pkg.ProcessOptions{
+		Source:      source,
+		Concurrency: processConcurrency,
+		DryRun:      processDryRun,
+	})
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+	if failed > 0 {
+		logger.Errorf("%d message(s) failed", failed)
+		os.Exit(1)
+	}
+}
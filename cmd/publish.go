@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/flanksource/batch-runner/pkg"
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/duty/context"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	publishBody     string
+	publishMetadata []string
+	publishCount    int
+	publishTopic    v1.TopicConfig
+)
+
+var PublishCmd = &cobra.Command{
+	Use:   "publish config.yaml --body @msg.json",
+	Short: "Send test messages to the queue of a config",
+	Args:  cobra.ExactArgs(1),
+	Run:   runPublish,
+}
+
+func init() {
+	PublishCmd.Flags().StringVarP(&publishBody, "body", "b", "", "Message body, or @file to read it from a file, or @- for stdin")
+	PublishCmd.Flags().StringArrayVar(&publishMetadata, "metadata", nil, "Message metadata as key=value, can be repeated")
+	PublishCmd.Flags().IntVar(&publishCount, "count", 1, "Number of messages to send")
+	bindTopicFlags(PublishCmd.Flags(), &publishTopic)
+	_ = PublishCmd.MarkFlagRequired("body")
+}
+
+// bindTopicFlags adds the flags naming where messages are sent, for queues that are fed by a different
+// topic or exchange than the one they are received from
+func bindTopicFlags(flags *pflag.FlagSet, topic *v1.TopicConfig) {
+	flags.StringVar(&topic.Topic, "topic", "", "GCP Pub/Sub topic that feeds the subscription of the config, or the Kafka topic to send to")
+	flags.StringVar(&topic.Exchange, "exchange", "", "RabbitMQ exchange that routes messages to the queue of the config")
+	flags.StringVar(&topic.KeyName, "key-name", "", "Metadata key sent as the Kafka message key or RabbitMQ routing key")
+}
+
+// readBody returns the value of --body, reading it from a file or stdin when it starts with @
+func readBody(body string) ([]byte, error) {
+	path, ok := strings.CutPrefix(body, "@")
+	if !ok {
+		return []byte(body), nil
+	}
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading body %s: %v", path, err)
+	}
+	return data, nil
+}
+
+func runPublish(cmd *cobra.Command, args []string) {
+	ctx := context.New()
+
+	configs, err := pkg.ParseConfigFiles(args)
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+	body, err := readBody(publishBody)
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+	metadata, err := parseMetadata(publishMetadata)
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+
+	// configs that consume the same queue only receive the messages once
+	sent := map[string]bool{}
+	for _, config := range configs {
+		queue := config.GetQueue()
+		if queue == nil {
+			logger.Fatalf("%s: no queue configured", args[0])
+			os.Exit(1)
+		}
+		if sent[queue.String()] {
+			continue
+		}
+		sent[queue.String()] = true
+
+		if err := pkg.SendMessages(ctx, config.QueueConfig, publishTopic, body, metadata, publishCount); err != nil {
+			logger.Fatalf(err.Error())
+			os.Exit(1)
+		}
+		ctx.Infof("Sent %d message(s) to %s", publishCount, queue)
+	}
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/flanksource/batch-runner/pkg"
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/duty/context"
+	"github.com/spf13/cobra"
+	"gocloud.dev/pubsub"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	renderMessage   string
+	renderMetadata  []string
+	renderID        string
+	renderCluster   bool
+	renderNamespace string
+)
+
+var RenderCmd = &cobra.Command{
+	Use:   "render config.yaml --message msg.json",
+	Short: "Print what a config does with a sample message, without running it",
+	Args:  cobra.ExactArgs(1),
+	Run:   runRender,
+}
+
+func init() {
+	RenderCmd.Flags().StringVarP(&renderMessage, "message", "m", "", "Path to the message body, or - for stdin")
+	RenderCmd.Flags().StringArrayVar(&renderMetadata, "metadata", nil, "Message metadata as key=value, can be repeated")
+	RenderCmd.Flags().StringVar(&renderID, "id", "dry-run", "Message id")
+	RenderCmd.Flags().BoolVar(&renderCluster, "cluster", false, "Resolve the configMap, secret and lookup template functions against the current cluster")
+	RenderCmd.Flags().StringVarP(&renderNamespace, "namespace", "n", "", "Namespace of the cluster lookups, defaults to the namespace of the current kubeconfig context")
+	_ = RenderCmd.MarkFlagRequired("message")
+}
+
+// readMessage returns the message of the render flags
+func readMessage() (*pubsub.Message, error) {
+	var body []byte
+	var err error
+	if renderMessage == "-" {
+		body, err = io.ReadAll(os.Stdin)
+	} else {
+		body, err = os.ReadFile(renderMessage)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading message %s: %v", renderMessage, err)
+	}
+
+	metadata, err := parseMetadata(renderMetadata)
+	if err != nil {
+		return nil, err
+	}
+	return &pubsub.Message{LoggableID: renderID, Body: body, Metadata: metadata}, nil
+}
+
+// parseMetadata returns the key=value pairs of a --metadata flag
+func parseMetadata(pairs []string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, kv := range pairs {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid metadata %q, must be key=value", kv)
+		}
+		metadata[k] = v
+	}
+	return metadata, nil
+}
+
+// kubeconfigNamespace returns the namespace of the current kubeconfig context, or default
+func kubeconfigNamespace() string {
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{})
+	namespace, _, err := config.Namespace()
+	if err != nil || namespace == "" {
+		return "default"
+	}
+	return namespace
+}
+
+func runRender(cmd *cobra.Command, args []string) {
+	ctx := context.New()
+
+	configs, err := pkg.ParseConfigFiles(args)
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+	msg, err := readMessage()
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+
+	var lookup *pkg.ClusterLookup
+	if renderCluster {
+		client, err := ctx.LocalKubernetes()
+		if err != nil {
+			logger.Fatalf("error getting Kubernetes client: %v", err)
+			os.Exit(1)
+		}
+		namespace := renderNamespace
+		if namespace == "" {
+			namespace = kubeconfigNamespace()
+		}
+		lookup = pkg.NewClusterLookup(ctx, client, namespace)
+	}
+
+	received := time.Now()
+	for i, config := range configs {
+		rendered, err := pkg.Render(ctx, &config, msg, received, lookup)
+		if err != nil {
+			logger.Fatalf("%s: %v", args[0], err)
+			os.Exit(1)
+		}
+		out, err := yaml.Marshal(rendered)
+		if err != nil {
+			logger.Fatalf(err.Error())
+			os.Exit(1)
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		fmt.Print(string(out))
+	}
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flanksource/batch-runner/pkg"
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/batch-runner/pkg/controller"
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/duty/context"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	captureDir string
+	captureMax int
+
+	replayCaptureDir string
+	replayTrigger    string
+	replaySince      time.Duration
+	replayTarget     string
+	replayRate       float64
+	replayDryRun     bool
+	replayTopic      v1.TopicConfig
+)
+
+// BindCaptureFlags adds the flags that keep failed messages of consumers for replay
+func BindCaptureFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&captureDir, "capture-dir", "", "Keep messages that fail in this directory so that they can be replayed")
+	flags.IntVar(&captureMax, "capture-max", 1000, "Number of failed messages kept for each trigger, older messages are deleted")
+}
+
+// StartCapture makes consumers keep their failed messages if a capture directory is configured
+func StartCapture() {
+	if captureDir == "" {
+		return
+	}
+	store, err := pkg.OpenCaptureStore(captureDir, captureMax)
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+	pkg.SetCaptureStore(store)
+}
+
+var ReplayCmd = &cobra.Command{
+	Use:   "replay [config.yaml] --trigger ns/name --since 2h",
+	Short: "Feed captured failed messages back into the queue of a trigger or its action",
+	Long: `Feed captured failed messages back into the queue of a trigger or its action.
+
+The current config of the trigger is used, either the BatchTrigger in the cluster or a config file, in which
+case --trigger defaults to its queue.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runReplay,
+}
+
+func init() {
+	ReplayCmd.Flags().StringVar(&replayCaptureDir, "capture-dir", "", "Directory the failed messages were captured in")
+	ReplayCmd.Flags().StringVar(&replayTrigger, "trigger", "", "BatchTrigger to replay as namespace/name, or the queue when running without the controller")
+	ReplayCmd.Flags().DurationVar(&replaySince, "since", 0, "Only replay messages that failed in this duration, e.g. 2h")
+	ReplayCmd.Flags().StringVar(&replayTarget, "to", pkg.ReplayToQueue, "Send the messages to the queue of the trigger, or run them through the action directly")
+	ReplayCmd.Flags().Float64Var(&replayRate, "rate", 10, "Maximum number of messages replayed each second, 0 is unlimited")
+	ReplayCmd.Flags().BoolVar(&replayDryRun, "dry-run", false, "List the messages that would be replayed")
+	bindTopicFlags(ReplayCmd.Flags(), &replayTopic)
+	_ = ReplayCmd.MarkFlagRequired("capture-dir")
+}
+
+func runReplay(cmd *cobra.Command, args []string) {
+	ctx := context.New()
+
+	// listing the messages of a BatchTrigger does not need its config from the cluster
+	var config *v1.Config
+	trigger := replayTrigger
+	if !replayDryRun || len(args) > 0 {
+		var meta *metav1.ObjectMeta
+		var err error
+		config, meta, trigger, err = replayConfig(ctx, args)
+		if err != nil {
+			logger.Fatalf(err.Error())
+			os.Exit(1)
+		}
+		if meta != nil {
+			// created objects are labelled with the trigger, as they are by its consumer
+			ctx = ctx.WithObject(*meta)
+		}
+	}
+	if trigger == "" {
+		logger.Fatalf("--trigger is required")
+		os.Exit(1)
+	}
+
+	store, err := pkg.OpenCaptureStore(replayCaptureDir, 0)
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+
+	opts := pkg.ReplayOptions{Target: replayTarget, Rate: replayRate, Destination: replayTopic}
+	if replaySince > 0 {
+		opts.Since = time.Now().Add(-replaySince)
+	}
+
+	if replayDryRun {
+		messages, err := store.List(trigger, opts.Since)
+		if err != nil {
+			logger.Fatalf(err.Error())
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FAILED\tMESSAGE\tSIZE\tERROR")
+		for _, m := range messages {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", m.FailedAt.Local().Format(time.DateTime), m.MessageID, len(m.Body), truncate(firstLine(m.Error), 80))
+		}
+		_ = w.Flush()
+		return
+	}
+
+	replayed, failed, err := pkg.ReplayMessages(ctx, store, config, trigger, opts)
+	logger.Infof("Replayed %d message(s) of %s to the %s", replayed, trigger, replayTarget)
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+	if failed > 0 {
+		logger.Errorf("%d message(s) failed again", failed)
+		os.Exit(1)
+	}
+}
+
+// replayConfig returns the config of the trigger to replay and its name, from a config file or the BatchTrigger
+// in the cluster, in which case its metadata is also returned
+func replayConfig(ctx context.Context, args []string) (*v1.Config, *metav1.ObjectMeta, string, error) {
+	if len(args) == 1 {
+		configs, err := pkg.ParseConfigFiles(args)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if len(configs) != 1 {
+			return nil, nil, "", fmt.Errorf("%s has %d configs, replay requires a single config", args[0], len(configs))
+		}
+		trigger := replayTrigger
+		if trigger == "" && configs[0].GetQueue() != nil {
+			trigger = configs[0].GetQueue().String()
+		}
+		return &configs[0], nil, trigger, nil
+	}
+
+	namespace, name, ok := strings.Cut(replayTrigger, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, nil, "", fmt.Errorf("--trigger must be namespace/name of a BatchTrigger, or a config file must be passed")
+	}
+	restConfig, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error getting Kubernetes config: %w", err)
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: controller.GetScheme()})
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error creating Kubernetes client: %w", err)
+	}
+	var bt v1.BatchTrigger
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &bt); err != nil {
+		return nil, nil, "", fmt.Errorf("error getting BatchTrigger %s: %w", replayTrigger, err)
+	}
+	return &bt.Spec, &bt.ObjectMeta, replayTrigger, nil
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	gocontext "context"
+
+	"github.com/flanksource/duty/telemetry"
+	"github.com/spf13/pflag"
+)
+
+// BindTracingFlags adds the flags of the OTLP exporter of traces
+func BindTracingFlags(flags *pflag.FlagSet) {
+	telemetry.BindFlags(flags, "batch-runner")
+}
+
+// StartTracing exports traces over OTLP if a collector is configured, the returned func flushes and stops
+// the exporter
+func StartTracing() func() {
+	stop := telemetry.InitTracer()
+	return func() {
+		_ = stop(gocontext.Background())
+	}
+}
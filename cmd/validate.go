@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/flanksource/batch-runner/pkg"
+	"github.com/flanksource/commons/logger"
+	"github.com/spf13/cobra"
+)
+
+var ValidateCmd = &cobra.Command{
+	Use:   "validate config.yaml [config.yaml...]",
+	Short: "Check configs for unknown fields, missing or conflicting actions and queues, and template syntax errors",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   runValidate,
+}
+
+func runValidate(cmd *cobra.Command, args []string) {
+	errs, err := pkg.ValidateConfigFiles(args)
+	if err != nil {
+		logger.Fatalf(err.Error())
+		os.Exit(1)
+	}
+	for _, e := range errs {
+		fmt.Fprintln(os.Stderr, e.Error())
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
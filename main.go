@@ -3,8 +3,6 @@ package main
 import (
 	"fmt"
 	"os"
-	"regexp"
-	"strings"
 	"sync"
 
 	"github.com/flanksource/batch-runner/cmd"
@@ -20,9 +18,6 @@ import (
 	_ "gocloud.dev/pubsub/mempubsub"
 	_ "gocloud.dev/pubsub/natspubsub"
 	_ "gocloud.dev/pubsub/rabbitpubsub"
-	"sigs.k8s.io/yaml"
-
-	batchv1alpha1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
 )
 
 var rootCmd = &cobra.Command{
@@ -32,36 +27,16 @@ var rootCmd = &cobra.Command{
 	Run:   run,
 }
 
-func parseConfigFile(configFiles []string) ([]batchv1alpha1.Config, error) {
-
-	var configs []batchv1alpha1.Config
-
-	for _, configFile := range configFiles {
-		configData, err := os.ReadFile(configFile)
-		if err != nil {
-			return nil, fmt.Errorf("error reading config file %s: %v", configFile, err)
-		}
-
-		re := regexp.MustCompile(`(?m)^---\n`)
-		for _, chunk := range re.Split(string(configData), -1) {
-			if strings.TrimSpace(chunk) == "" {
-				continue
-			}
-
-			var config batchv1alpha1.Config
-			if err := yaml.Unmarshal([]byte(chunk), &config); err != nil {
-				return nil, fmt.Errorf("error parsing config file: %w", err)
-			}
-			configs = append(configs, config)
-		}
-	}
-	return configs, nil
-}
-
-func run(cmd *cobra.Command, args []string) {
+func run(_ *cobra.Command, args []string) {
 	ctx := context.New()
 
 	shutdown.WaitForSignal()
+	stopTracing := cmd.StartTracing()
+	shutdown.AddHook(stopTracing)
+	defer stopTracing()
+	cmd.StartAudit(ctx)
+	cmd.StartCapture()
+	cmd.StartMetrics()
 
 	configFiles = append(configFiles, args...)
 
@@ -69,7 +44,7 @@ func run(cmd *cobra.Command, args []string) {
 
 	wg := sync.WaitGroup{}
 
-	configs, err := parseConfigFile(configFiles)
+	configs, err := pkg.ParseConfigFiles(configFiles)
 	if err != nil {
 		logger.Fatalf(err.Error())
 		os.Exit(1)
@@ -95,8 +70,18 @@ func main() {
 	rootCmd.Flags().StringArrayVarP(&configFiles, "config", "c", []string{}, "Path to config file")
 	_ = rootCmd.Flags().MarkDeprecated("config", "Pass the config files as arguments instead")
 	logger.BindFlags(rootCmd.Flags())
+	cmd.BindAuditFlags(rootCmd.Flags())
+	cmd.BindCaptureFlags(rootCmd.Flags())
+	cmd.BindMetricsFlags(rootCmd.Flags())
+	cmd.BindTracingFlags(rootCmd.Flags())
 
 	rootCmd.AddCommand(cmd.ControllerCmd)
+	rootCmd.AddCommand(cmd.RenderCmd)
+	rootCmd.AddCommand(cmd.ValidateCmd)
+	rootCmd.AddCommand(cmd.PublishCmd)
+	rootCmd.AddCommand(cmd.ProcessCmd)
+	rootCmd.AddCommand(cmd.AuditCmd)
+	rootCmd.AddCommand(cmd.ReplayCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -0,0 +1,221 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	dutyKubernetes "github.com/flanksource/duty/kubernetes"
+	"go.opentelemetry.io/otel/attribute"
+	"gocloud.dev/pubsub"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ActionResult is the outcome of running the action of a rendered message
+type ActionResult struct {
+	// Object is the Pod, Job or owning resource that was sent to the API server, errors creating it are
+	// retried if the API server reports them as retryable rather than using a retry policy
+	Object metav1.Object
+	// Created is the object that was created, e.g. "Job default/sync-1", or what was done by actions that
+	// do not create objects, e.g. "POST https://example.com returned 200"
+	Created string
+	// Retry is the retry policy of actions that do not create objects
+	Retry *v1.Retry
+	// Permanent errors are never retried
+	Permanent bool
+	// Skipped is true when the object already existed and was kept by the skip conflict policy
+	Skipped bool
+	// Artifacts are the URLs of the artifacts uploaded by exec actions
+	Artifacts []string
+}
+
+// RunAction runs the action of a rendered message, topic must be open for publish actions and client is only
+// used by actions that create objects or exec into pods. Errors are logged, and the result describes how they
+// should be retried. The trace context of the action is passed to created Pods and Jobs, and to exec scripts.
+func RunAction(ctx context.Context, client *dutyKubernetes.Client, config *v1.Config, rendered *Rendered, topic *pubsub.Topic, callbacks *ConsumerCallbacks) (ActionResult, error) {
+	ctx, span := startSpan(ctx, actionSpanName(rendered), attribute.String("batch_runner.action", strings.Join(configActions(config), ",")))
+	applyWorkloadTraceContext(ctx, rendered, config.Mode)
+	if rendered.Exec != nil {
+		applyExecTraceContext(ctx, rendered.Exec)
+	}
+
+	result, err := runAction(ctx, client, config, rendered, topic, callbacks)
+	if result.Created != "" {
+		span.SetAttributes(attribute.String("batch_runner.created", result.Created))
+	}
+	endSpan(span, err)
+	return result, err
+}
+
+// actionSpanName is create for actions that create objects, exec for scripts and pod exec, or else the action
+func actionSpanName(rendered *Rendered) string {
+	switch {
+	case rendered.Pod != nil, rendered.Job != nil, rendered.Resources != nil:
+		return "create"
+	case rendered.Exec != nil, rendered.PodExec != nil:
+		return "exec"
+	case rendered.HTTP != nil:
+		return "http"
+	case rendered.Publish != nil:
+		return "publish"
+	case rendered.SQL != nil:
+		return "sql"
+	case rendered.Helm != nil:
+		return "helm"
+	case rendered.Git != nil:
+		return "git"
+	}
+	return "action"
+}
+
+func runAction(ctx context.Context, client *dutyKubernetes.Client, config *v1.Config, rendered *Rendered, topic *pubsub.Topic, callbacks *ConsumerCallbacks) (ActionResult, error) {
+	switch {
+	case rendered.Pod != nil:
+		pod := rendered.Pod
+		p, skipped, err := createOrApply(ctx, client, client.CoreV1().Pods(pod.Namespace), pod, corev1.SchemeGroupVersion.WithKind("Pod"), config)
+		return ActionResult{Object: p, Created: objectName("Pod", p), Skipped: skipped}, err
+	case rendered.Job != nil:
+		job := rendered.Job
+		created, skipped, err := createOrApply(ctx, client, client.BatchV1().Jobs(job.Namespace), job, batchv1.SchemeGroupVersion.WithKind("Job"), config)
+		return ActionResult{Object: created, Created: objectName("Job", created), Skipped: skipped}, err
+	case rendered.Exec != nil:
+		exec := rendered.Exec
+		if exec.Retry == nil {
+			exec.Retry = &v1.Retry{
+				Attempts: 3,
+				Delay:    30,
+			}
+		}
+		result := ActionResult{Retry: exec.Retry}
+
+		details, err := runExec(ctx, *exec)
+		if config.ArtifactStore != nil && details != nil && len(details.Artifacts) > 0 {
+			result.Artifacts = uploadExecArtifacts(ctx, *config.ArtifactStore, rendered.Templater, rendered.Provenance, details.Artifacts, callbacks)
+		}
+		if err == nil && details.ExitCode == 0 {
+			ctx.Tracef("%s", details.String())
+			result.Created = "script exited with 0"
+			return result, nil
+		}
+
+		if err != nil {
+			ctx.Errorf("%s running %s: %s\n%s", FailureReason(err), exec.Script, err, details)
+			return result, err
+		}
+		ctx.Errorf("Script returned non-zero exit code: %s", details)
+		return result, fmt.Errorf("script returned non-zero exit code: %s", details)
+	case rendered.HTTP != nil:
+		action := rendered.HTTP
+		result := ActionResult{Retry: action.Retry}
+		response, err := doHTTP(ctx, *action, string(rendered.Decoded))
+		if err != nil {
+			ctx.Errorf("Error calling %s: %v", action, err)
+			return result, err
+		}
+		result.Created = fmt.Sprintf("%s returned %d", action, response.StatusCode)
+		ctx.Infof("%s", result.Created)
+		return result, nil
+	case rendered.Publish != nil:
+		result := ActionResult{Retry: config.Publish.Retry}
+		out := &pubsub.Message{Body: []byte(rendered.Publish.Body), Metadata: rendered.Publish.Metadata}
+		if err := topic.Send(ctx, out); err != nil {
+			ctx.Errorf("Error publishing to %s: %v", config.Publish, err)
+			return result, err
+		}
+		result.Created = fmt.Sprintf("Published to %s", config.Publish)
+		ctx.Infof("%s", result.Created)
+		return result, nil
+	case rendered.SQL != nil:
+		action := rendered.SQL
+		result := ActionResult{Retry: action.Retry}
+		rows, err := runSQL(ctx, *action)
+		if err != nil {
+			ctx.Errorf("Error running sql against %s: %v", action, err)
+			return result, err
+		}
+		result.Created = fmt.Sprintf("Ran %s, %d rows affected", action, rows)
+		ctx.Infof("%s", result.Created)
+		return result, nil
+	case rendered.Helm != nil:
+		action := rendered.Helm
+		result := ActionResult{Retry: action.Retry}
+		cfg, err := newHelmConfiguration(ctx, client, helmNamespace(ctx, *action))
+		if err != nil {
+			ctx.Errorf("Error running helm for %s: %v", action, err)
+			return result, err
+		}
+		release, err := runHelmAction(ctx, cfg, *action)
+		if err != nil {
+			ctx.Errorf("Error running helm for %s: %v", action, err)
+			return result, err
+		}
+		if release == nil {
+			result.Created = fmt.Sprintf("Uninstalled %s", action)
+		} else {
+			// the release is reported like created objects, by the Secret that stores its revision
+			result.Object = releaseObject(ctx, client, release)
+			result.Created = fmt.Sprintf("Installed %s", releaseName(release))
+		}
+		ctx.Infof("%s", result.Created)
+		return result, nil
+	case rendered.Git != nil:
+		action := rendered.Git
+		result := ActionResult{Retry: action.Retry}
+		content, err := marshalManifests(action.Manifests)
+		if err != nil {
+			ctx.Errorf("Error rendering manifests for %s: %v", action, err)
+			result.Permanent = true
+			return result, err
+		}
+
+		hash, err := commitToGit(ctx, *action, content)
+		if err != nil {
+			ctx.Errorf("Error committing to %s: %v", action, err)
+			return result, err
+		}
+		if hash == "" {
+			result.Created = fmt.Sprintf("%s is up to date", action)
+		} else {
+			result.Created = fmt.Sprintf("Pushed %s (%s)", action, hash)
+		}
+		ctx.Infof("%s", result.Created)
+		return result, nil
+	case rendered.PodExec != nil:
+		action := rendered.PodExec
+		if action.Retry == nil {
+			action.Retry = &v1.Retry{
+				Attempts: 3,
+				Delay:    30,
+			}
+		}
+		result := ActionResult{Retry: action.Retry}
+
+		output, err := runPodExec(ctx, client, *action)
+		if err == nil && output.ExitCode == 0 {
+			result.Created = fmt.Sprintf("Ran %s in %s", strings.Join(action.Command, " "), output.Pod)
+			ctx.Infof("%s", result.Created)
+			return result, nil
+		}
+
+		if err != nil {
+			ctx.Errorf("%s running %s: %v", FailureReason(err), action, err)
+			return result, err
+		}
+		ctx.Errorf("Command returned non-zero exit code: %s", output)
+		return result, fmt.Errorf("command returned non-zero exit code: %s", output)
+	default:
+		owner, skipped, err := createResources(ctx, client, rendered.Resources, config)
+		return ActionResult{Object: owner, Created: objectName(owner.GetKind(), owner), Skipped: skipped}, err
+	}
+}
+
+// objectName returns the kind and name of an object, e.g. "Job default/sync-1"
+func objectName(kind string, o metav1.Object) string {
+	if o.GetNamespace() == "" {
+		return fmt.Sprintf("%s %s", kind, o.GetName())
+	}
+	return fmt.Sprintf("%s %s/%s", kind, o.GetNamespace(), o.GetName())
+}
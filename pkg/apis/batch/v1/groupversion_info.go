@@ -8,9 +8,12 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/scheme"
 )
 
+// Group is the API group of the batch-runner types, also used as the prefix for labels and annotations
+const Group = "batch.flanksource.com"
+
 var (
 	// GroupVersion is group version used to register these objects
-	GroupVersion = schema.GroupVersion{Group: "batch.flanksource.com", Version: "v1"}
+	GroupVersion = schema.GroupVersion{Group: Group, Version: "v1"}
 
 	// SchemeBuilder is used to add go types to the GroupVersionKind scheme
 	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
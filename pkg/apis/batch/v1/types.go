@@ -2,16 +2,20 @@ package v1
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	"github.com/flanksource/duty/connection"
 	dutyps "github.com/flanksource/duty/pubsub"
 	"github.com/flanksource/duty/shell"
 	"github.com/flanksource/duty/types"
+	"github.com/samber/lo"
 )
 
 // +kubebuilder:object:root=true
@@ -50,6 +54,9 @@ type BatchTriggerStatus struct {
 	// LastErrorTime is when the last error occurred
 	LastErrorTime *metav1.Time `json:"lastErrorTime,omitempty"`
 
+	// LastArtifacts are the URLs of the artifacts uploaded for the most recent message that produced any
+	LastArtifacts []string `json:"lastArtifacts,omitempty"`
+
 	// Conditions represent the latest available observations of the BatchTrigger's state
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
@@ -66,13 +73,101 @@ type BatchTriggerList struct {
 // +kubebuilder:object:generate=true
 type Config struct {
 	// +optional
-	LogLevel           string       `json:"logLevel,omitempty"`
-	Pod                *corev1.Pod  `json:"pod,omitempty"`
-	Job                *batchv1.Job `json:"job,omitempty"`
-	Exec               *ExecAction  `json:"exec,omitempty"`
+	LogLevel string       `json:"logLevel,omitempty"`
+	Pod      *corev1.Pod  `json:"pod,omitempty"`
+	Job      *batchv1.Job `json:"job,omitempty"`
+	Exec     *ExecAction  `json:"exec,omitempty"`
+	// HTTP calls an API with the message instead of creating a workload
+	HTTP *HTTPAction `json:"http,omitempty"`
+	// Publish forwards the message to another queue, optionally transforming the body and metadata
+	Publish *PublishAction `json:"publish,omitempty"`
+	// SQL runs statements against a database connection
+	SQL *SQLAction `json:"sql,omitempty"`
+	// Helm installs, upgrades or uninstalls a chart release with the Helm SDK
+	Helm *HelmAction `json:"helm,omitempty"`
+	// Git commits rendered manifests to a repository, for clusters that are managed by Flux or Argo
+	Git *GitAction `json:"git,omitempty"`
+	// PodExec runs a command in an existing pod, e.g. a pod with a database CLI and the right mounts
+	PodExec *PodExecAction `json:"podExec,omitempty"`
+	// ArtifactStore uploads the artifacts collected by exec to a bucket
+	ArtifactStore *ArtifactStore `json:"artifactStore,omitempty"`
+	// Resources creates multiple objects per message, e.g. a Secret holding the payload and the Job that mounts it
+	Resources *ResourcesAction `json:"resources,omitempty"`
+	// Template selects the engine and delimiters used to template actions with the message
+	Template *TemplateConfig `json:"template,omitempty"`
+	// OnConflict controls what happens when a Pod, Job or resource being created already exists
+	// +kubebuilder:validation:Enum=fail;skip;replace;suffix
+	OnConflict ConflictPolicy `json:"onConflict,omitempty"`
+	// Mode is either create (default) or apply, which uses server-side apply so that repeated
+	// messages converge the same object instead of failing with AlreadyExists
+	// +kubebuilder:validation:Enum=create;apply
+	Mode Mode `json:"mode,omitempty"`
+	// OwnerReference makes the BatchTrigger an owner of created objects in the same namespace,
+	// so they are garbage collected when the trigger is deleted, see DeletionPolicy
+	OwnerReference bool `json:"ownerReference,omitempty"`
+	// TTLAfterFinished deletes created Pods this long after they have succeeded or failed,
+	// and is used as the default ttlSecondsAfterFinished of created Jobs
+	TTLAfterFinished *metav1.Duration `json:"ttlAfterFinished,omitempty"`
+	// DeletionPolicy controls whether deleting the trigger also deletes the objects it owns (Delete),
+	// or removes the owner references so that they are kept (Orphan)
+	// +kubebuilder:validation:Enum=Delete;Orphan
+	DeletionPolicy     DeletionPolicy `json:"deletionPolicy,omitempty"`
 	dutyps.QueueConfig `json:",inline"`
 }
 
+// Mode determines how Pods, Jobs and resources are sent to the API server
+type Mode string
+
+const (
+	ModeCreate Mode = "create"
+	ModeApply  Mode = "apply"
+)
+
+// TemplateEngine is the language of the expressions in templated fields
+type TemplateEngine string
+
+const (
+	TemplateEngineGo         TemplateEngine = "gotemplate"
+	TemplateEngineCEL        TemplateEngine = "cel"
+	TemplateEngineJavascript TemplateEngine = "javascript"
+)
+
+// TemplateConfig controls how actions are templated, e.g. to let Argo or Helm {{...}} placeholders pass through
+type TemplateConfig struct {
+	// Engine is gotemplate (default), cel or javascript
+	// +kubebuilder:validation:Enum=gotemplate;cel;javascript
+	Engine TemplateEngine `json:"engine,omitempty"`
+	// Delims are the left and right delimiters of expressions, e.g. ["[[", "]]"],
+	// defaults to ["{{", "}}"] for gotemplate and ["$(", ")"] for cel and javascript
+	// +kubebuilder:validation:MinItems=2
+	// +kubebuilder:validation:MaxItems=2
+	Delims []string `json:"delims,omitempty"`
+	// Env lists the environment variables of batch-runner that are available to templates as .env
+	Env []string `json:"env,omitempty"`
+}
+
+// DeletionPolicy determines what happens to owned objects when a BatchTrigger is deleted
+type DeletionPolicy string
+
+const (
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+)
+
+// ConflictPolicy determines how objects that already exist are handled
+type ConflictPolicy string
+
+const (
+	// ConflictFail fails the message without retrying, this is the default
+	ConflictFail ConflictPolicy = "fail"
+	// ConflictSkip treats the existing object as successfully created
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictReplace deletes the existing object and creates it again
+	ConflictReplace ConflictPolicy = "replace"
+	// ConflictSuffix appends a short random suffix to the name of the object
+	ConflictSuffix ConflictPolicy = "suffix"
+)
+
 type S string
 
 func (s S) String() string {
@@ -89,6 +184,27 @@ func (c *Config) GetDestination() fmt.Stringer {
 	if c.Exec != nil {
 		return c.Exec
 	}
+	if c.Resources != nil {
+		return c.Resources
+	}
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	if c.Publish != nil {
+		return c.Publish
+	}
+	if c.SQL != nil {
+		return c.SQL
+	}
+	if c.Helm != nil {
+		return c.Helm
+	}
+	if c.Git != nil {
+		return c.Git
+	}
+	if c.PodExec != nil {
+		return c.PodExec
+	}
 	return nil
 }
 
@@ -111,10 +227,229 @@ type ExecAction struct {
 	EnvVars []types.EnvVar `yaml:"env,omitempty" json:"env,omitempty"`
 	// Checkout details the git repository that should be mounted to the process
 	Checkout *connection.GitConnection `yaml:"checkout,omitempty" json:"checkout,omitempty"`
+	// Timeout after which the process group of the script is killed, defaults to no timeout
+	Timeout *metav1.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// KillGracePeriod is the time between sending SIGTERM and SIGKILL to the process group
+	// when the timeout expires or the consumer is stopped, defaults to 10s
+	KillGracePeriod *metav1.Duration `yaml:"killGracePeriod,omitempty" json:"killGracePeriod,omitempty"`
+	// Limits are resource limits applied to the script, not supported on windows
+	Limits *ExecLimits `yaml:"limits,omitempty" json:"limits,omitempty"`
+	// RunAsUser is the UID the script is run as, batch-runner must run as root to change users
+	RunAsUser *int64 `yaml:"runAsUser,omitempty" json:"runAsUser,omitempty"`
+	// RunAsGroup is the GID the script is run as, defaults to the primary group of RunAsUser
+	RunAsGroup *int64 `yaml:"runAsGroup,omitempty" json:"runAsGroup,omitempty"`
 
 	Retry *Retry `yaml:"retry,omitempty" json:"retry,omitempty"`
 }
 
+// ArtifactStore is a gocloud.dev/blob bucket that artifacts are uploaded to
+type ArtifactStore struct {
+	// URL of the bucket, e.g. s3://bucket?region=us-east-1, gs://bucket, azblob://container or file:///var/artifacts
+	URL string `json:"url"`
+	// Prefix of the uploaded artifacts, templated with the message, defaults to <trigger namespace>/<trigger>/<message id>
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// ExecLimits are applied to the script using ulimit
+type ExecLimits struct {
+	// CPUSeconds is the maximum CPU time of the script
+	CPUSeconds int64 `yaml:"cpuSeconds,omitempty" json:"cpuSeconds,omitempty"`
+	// Memory is the maximum virtual memory of each process, e.g. 512Mi
+	Memory *resource.Quantity `yaml:"memory,omitempty" json:"memory,omitempty"`
+	// OpenFiles is the maximum number of open file descriptors of each process
+	OpenFiles int64 `yaml:"openFiles,omitempty" json:"openFiles,omitempty"`
+}
+
+// ResourcesAction creates a set of objects in dependency order, e.g. ConfigMaps and Secrets
+// before the workloads that mount them. Objects created earlier in the same namespace are owned
+// by the last object created, so they are garbage collected alongside it. If any create fails,
+// the objects already created for the message are deleted.
+type ResourcesAction struct {
+	// Manifests is a list of objects to create, each field is templated with the message
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Manifests []unstructured.Unstructured `json:"manifests,omitempty"`
+	// Template is a multi-document YAML template that is rendered with the message and then split into objects
+	Template string `json:"template,omitempty"`
+}
+
+func (r ResourcesAction) String() string {
+	var names []string
+	for _, m := range r.Manifests {
+		names = append(names, fmt.Sprintf("%s/%s/%s", m.GetKind(), m.GetNamespace(), m.GetName()))
+	}
+	if r.Template != "" {
+		names = append(names, "template")
+	}
+	return strings.Join(names, ",")
+}
+
+// HTTPAction sends a templated request for each message, the URL and authentication
+// can be specified inline or by referencing a connection
+type HTTPAction struct {
+	connection.HTTPConnection `json:",inline"`
+	// Method defaults to POST
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body defaults to the raw message body
+	Body string `json:"body,omitempty"`
+	// ExpectedStatus is the list of response codes treated as success, defaults to any 2xx code
+	ExpectedStatus []int `json:"expectedStatus,omitempty"`
+	// Timeout for the request, defaults to 1m
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	Retry *Retry `json:"retry,omitempty"`
+}
+
+func (h HTTPAction) String() string {
+	return fmt.Sprintf("%s %s", h.GetMethod(), lo.CoalesceOrEmpty(h.URL, h.ConnectionName))
+}
+
+func (h HTTPAction) GetMethod() string {
+	if h.Method == "" {
+		return "POST"
+	}
+	return strings.ToUpper(h.Method)
+}
+
+// PublishAction sends a message to a different queue, e.g. to bridge Kafka to SQS.
+// The queue is opened once when the consumer starts, only the body and metadata are templated.
+type PublishAction struct {
+	// Body of the published message, defaults to the received body
+	Body string `json:"body,omitempty"`
+	// Metadata is added to the metadata of the received message, e.g. SQS message attributes or Kafka headers
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	dutyps.QueueConfig `json:",inline"`
+	TopicConfig        `json:",inline"`
+
+	Retry *Retry `json:"retry,omitempty"`
+}
+
+// TopicConfig names where messages are sent, for queues where it differs from where they are received
+type TopicConfig struct {
+	// Topic is the GCP Pub/Sub topic that messages are published to (required for pubsub, whose subscription
+	// is only used to receive), or overrides kafka.topic
+	Topic string `json:"topic,omitempty"`
+	// Exchange is the RabbitMQ exchange that messages are published to (required for rabbitmq), which routes
+	// them to its bound queues
+	Exchange string `json:"exchange,omitempty"`
+	// KeyName is the metadata key whose value is sent as the Kafka message key or RabbitMQ routing key
+	KeyName string `json:"keyName,omitempty"`
+	// KafkaVersion is the version of the Kafka brokers, e.g. 3.6.0, defaults to 0.11.0 which is the minimum
+	// that supports headers
+	KafkaVersion string `json:"kafkaVersion,omitempty"`
+}
+
+func (p PublishAction) String() string {
+	if q := p.GetQueue(); q != nil {
+		return q.String()
+	}
+	return ""
+}
+
+// SQLAction runs one or more statements for each message, optionally in a single transaction
+type SQLAction struct {
+	// Type is one of postgres (default), mysql, sql_server or sqlite
+	connection.SQLConnection `json:",inline"`
+	// Statements are executed in order
+	Statements []SQLStatement `json:"statements"`
+	// Transaction runs all statements in a single transaction that is rolled back if any statement
+	// or row count assertion fails
+	Transaction bool `json:"transaction,omitempty"`
+
+	Retry *Retry `json:"retry,omitempty"`
+}
+
+type SQLStatement struct {
+	// Query is the statement to execute, it is not templated so message values must be passed using bind
+	// parameters ($1 for postgres, ? for mysql and sqlite, @p1 for sql_server)
+	Query string `json:"query"`
+	// Args are the templated values of the bind parameters
+	Args []string `json:"args,omitempty"`
+	// RowsAffected fails the statement unless exactly this many rows are affected
+	RowsAffected *int64 `json:"rowsAffected,omitempty"`
+}
+
+func (s SQLAction) String() string {
+	return fmt.Sprintf("%s (%d statements)", lo.CoalesceOrEmpty(s.ConnectionName, s.Type, "postgres"), len(s.Statements))
+}
+
+// HelmAction installs or upgrades a release for each message using the Helm SDK, so the helm CLI is not
+// required, or uninstalls it when Uninstall renders to true
+type HelmAction struct {
+	// Chart is a local path, an OCI reference (oci://...) or the name of a chart in Repo
+	Chart   string `json:"chart"`
+	Repo    string `json:"repo,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Release is the name of the release, e.g. "env-{{.id}}"
+	Release   string `json:"release"`
+	Namespace string `json:"namespace,omitempty"`
+	// Values is a YAML template of the release values
+	Values string `json:"values,omitempty"`
+	// Uninstall is a template that uninstalls the release when it renders to true, e.g. {{ eq .action "destroy" }}
+	Uninstall string `json:"uninstall,omitempty"`
+	// Wait until all resources of the release are ready
+	Wait bool `json:"wait,omitempty"`
+	// Timeout for helm operations, defaults to 5m
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	Retry *Retry `json:"retry,omitempty"`
+}
+
+func (h HelmAction) String() string {
+	return fmt.Sprintf("%s/%s (%s)", h.Namespace, h.Release, h.Chart)
+}
+
+// GitAction renders manifests in the same way as ResourcesAction, writes them to a file in a
+// repository, and commits and pushes the change
+type GitAction struct {
+	// GitConnection is the repository and the branch that is cloned, which defaults to main
+	connection.GitConnection `json:",inline"`
+	ResourcesAction          `json:",inline"`
+	// Path of the file in the repository the manifests are written to, e.g. "envs/{{.id}}/manifests.yaml"
+	Path string `json:"path"`
+	// PushBranch is the branch the commit is pushed to, defaults to the cloned branch.
+	// It is created from the cloned branch if it does not exist.
+	PushBranch string `json:"pushBranch,omitempty"`
+	// CommitMessage defaults to "Update <path>"
+	CommitMessage string `json:"commitMessage,omitempty"`
+	// AuthorName defaults to batch-runner
+	AuthorName string `json:"authorName,omitempty"`
+	// AuthorEmail defaults to batch-runner@flanksource.com
+	AuthorEmail string `json:"authorEmail,omitempty"`
+
+	Retry *Retry `json:"retry,omitempty"`
+}
+
+func (g GitAction) String() string {
+	return fmt.Sprintf("%s/%s", lo.CoalesceOrEmpty(g.URL, g.Connection), g.Path)
+}
+
+// PodExecAction runs a command in a container of an existing pod, selected by name or by a label selector
+type PodExecAction struct {
+	// Name of the pod, either name or selector is required
+	Name string `json:"name,omitempty"`
+	// Selector is a label selector, e.g. "app=psql", the command runs in the first running pod that matches
+	Selector string `json:"selector,omitempty"`
+	// Namespace of the pod, defaults to the namespace of the BatchTrigger
+	Namespace string `json:"namespace,omitempty"`
+	// Container defaults to the first container of the pod
+	Container string `json:"container,omitempty"`
+	// Command is run without a shell, use ["sh", "-c", "..."] for shell features
+	Command []string `json:"command"`
+	// Stdin is written to the standard input of the command
+	Stdin string `json:"stdin,omitempty"`
+	// Timeout after which the command is aborted, a non-zero exit code or timeout is retried and then failed
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	Retry *Retry `json:"retry,omitempty"`
+}
+
+func (p PodExecAction) String() string {
+	return fmt.Sprintf("%s/%s", p.Namespace, lo.CoalesceOrEmpty(p.Name, p.Selector))
+}
+
 type Retry struct {
 	Attempts int `json:"attempts,omitempty"`
 	// Delay is the time in seconds to wait between retries
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	dutyKubernetes "github.com/flanksource/duty/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// FieldManager is the field manager used for server-side apply
+const FieldManager = "batch-runner"
+
+// toUnstructured converts obj for server-side apply, defaulting the kind to gvk and dropping the status
+func toUnstructured(obj runtime.Object, gvk schema.GroupVersionKind) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	if u.GetKind() == "" {
+		u.SetGroupVersionKind(gvk)
+	}
+	unstructured.RemoveNestedField(u.Object, "status")
+	unstructured.RemoveNestedField(u.Object, "metadata", "creationTimestamp")
+	return u, nil
+}
+
+// applyObject creates or updates obj using server-side apply, taking ownership of any conflicting fields
+func applyObject(ctx context.Context, client *dutyKubernetes.Client, obj runtime.Object, gvk schema.GroupVersionKind) (*unstructured.Unstructured, error) {
+	u, err := toUnstructured(obj, gvk)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := resourceClient(ctx, client, *u)
+	if err != nil {
+		return nil, err
+	}
+
+	return rc.Apply(ctx, u.GetName(), u, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+}
+
+type kubeObject interface {
+	metav1.Object
+	runtime.Object
+}
+
+// createOrApply sends obj to the API server using the mode and conflict policy of the config,
+// returning the object as stored by the API server, or obj itself on failure. The returned bool is true
+// when the object already existed and was kept by the skip conflict policy.
+func createOrApply[T kubeObject](ctx context.Context, client *dutyKubernetes.Client, c objectClient[T], obj T, gvk schema.GroupVersionKind, config *v1.Config) (metav1.Object, bool, error) {
+	if config.Mode == v1.ModeApply {
+		applied, err := applyObject(ctx, client, obj, gvk)
+		if err != nil {
+			return obj, false, err
+		}
+		return applied, false, nil
+	}
+
+	created, isNew, err := createWithPolicy(ctx, c, obj, config.OnConflict)
+	if err != nil {
+		return obj, false, err
+	}
+	skipped := !isNew && config.OnConflict == v1.ConflictSkip
+	if ts := created.GetCreationTimestamp(); ts.IsZero() {
+		return obj, skipped, nil
+	}
+	return created, skipped, nil
+}
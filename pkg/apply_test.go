@@ -0,0 +1,26 @@
+package pkg
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestToUnstructured(t *testing.T) {
+	RegisterTestingT(t)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "batch-a", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "test", Image: "busybox"}}},
+	}
+
+	u, err := toUnstructured(pod, corev1.SchemeGroupVersion.WithKind("Pod"))
+	Expect(err).To(BeNil())
+	Expect(u.GetAPIVersion()).To(Equal("v1"))
+	Expect(u.GetKind()).To(Equal("Pod"))
+	Expect(u.GetName()).To(Equal("batch-a"))
+	Expect(u.Object).ToNot(HaveKey("status"))
+	Expect(u.Object["metadata"]).ToNot(HaveKey("creationTimestamp"))
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/flanksource/artifacts"
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	"github.com/samber/oops"
+	"gocloud.dev/blob"
+
+	_ "gocloud.dev/blob/azureblob"
+	_ "gocloud.dev/blob/fileblob"
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/memblob"
+	_ "gocloud.dev/blob/s3blob"
+)
+
+// artifactPrefix returns the templated prefix of the store, or the trigger and message id
+func artifactPrefix(store v1.ArtifactStore, templater Templater, provenance Provenance) (string, error) {
+	if store.Prefix != "" {
+		prefix, err := templater.Template(store.Prefix)
+		if err != nil {
+			return "", err
+		}
+		return strings.Trim(prefix, "/"), nil
+	}
+
+	var parts []string
+	if provenance.Trigger != nil {
+		parts = append(parts, provenance.Trigger.Namespace, provenance.Trigger.Name)
+	}
+	parts = append(parts, provenance.MessageID)
+	return path.Join(parts...), nil
+}
+
+// artifactURL returns the URL of key in the bucket at bucketURL, without any bucket options
+func artifactURL(bucketURL, key string) string {
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return key
+	}
+	u.RawQuery = ""
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	return u.String()
+}
+
+// uploadArtifacts uploads and closes artifacts under prefix, returning the URLs of the uploaded artifacts
+func uploadArtifacts(ctx context.Context, store v1.ArtifactStore, prefix string, list []artifacts.Artifact) ([]string, error) {
+	defer func() {
+		for _, a := range list {
+			if a.Content != nil {
+				_ = a.Content.Close()
+			}
+		}
+	}()
+
+	bucket, err := blob.OpenBucket(ctx, store.URL)
+	if err != nil {
+		return nil, oops.Wrapf(err, "error opening artifact store")
+	}
+	defer bucket.Close()
+
+	var urls []string
+	for _, a := range list {
+		key := path.Join(prefix, strings.TrimPrefix(path.Clean("/"+a.Path), "/"))
+		w, err := bucket.NewWriter(ctx, key, &blob.WriterOptions{ContentType: a.ContentType})
+		if err != nil {
+			return urls, oops.Wrapf(err, "error uploading %s", key)
+		}
+		if _, err := io.Copy(w, a.Content); err != nil {
+			_ = w.Close()
+			return urls, oops.Wrapf(err, "error uploading %s", key)
+		}
+		if err := w.Close(); err != nil {
+			return urls, oops.Wrapf(err, "error uploading %s", key)
+		}
+		urls = append(urls, artifactURL(store.URL, key))
+	}
+	return urls, nil
+}
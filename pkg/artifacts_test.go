@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flanksource/artifacts"
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	"github.com/flanksource/gomplate/v3"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestArtifactPrefix(t *testing.T) {
+	RegisterTestingT(t)
+
+	provenance := Provenance{
+		Trigger:   &metav1.ObjectMeta{Name: "reports", Namespace: "default"},
+		MessageID: "msg-1",
+	}
+	templater := gomplate.StructTemplater{
+		Context:   context.New().Context,
+		Values:    map[string]any{"id": "42"},
+		DelimSets: []gomplate.Delims{{Left: "{{", Right: "}}"}},
+	}
+
+	t.Run("defaults to the trigger and message id", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		prefix, err := artifactPrefix(v1.ArtifactStore{URL: "mem://"}, templater, provenance)
+		Expect(err).To(BeNil())
+		Expect(prefix).To(Equal("default/reports/msg-1"))
+	})
+
+	t.Run("templates the prefix", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		prefix, err := artifactPrefix(v1.ArtifactStore{URL: "mem://", Prefix: "/reports/{{.id}}/"}, templater, provenance)
+		Expect(err).To(BeNil())
+		Expect(prefix).To(Equal("reports/42"))
+	})
+}
+
+func TestUploadArtifacts(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir := t.TempDir()
+	store := v1.ArtifactStore{URL: "file://" + dir}
+	urls, err := uploadArtifacts(context.New(), store, "default/reports/msg-1", []artifacts.Artifact{
+		{Path: "out/report.html", ContentType: "text/html", Content: io.NopCloser(strings.NewReader("<html/>"))},
+		{Path: "../escape.txt", Content: io.NopCloser(strings.NewReader("text"))},
+	})
+	Expect(err).To(BeNil())
+	Expect(urls).To(Equal([]string{
+		"file://" + dir + "/default/reports/msg-1/out/report.html",
+		"file://" + dir + "/default/reports/msg-1/escape.txt",
+	}))
+
+	content, err := os.ReadFile(filepath.Join(dir, "default/reports/msg-1/out/report.html"))
+	Expect(err).To(BeNil())
+	Expect(string(content)).To(Equal("<html/>"))
+}
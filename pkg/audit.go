@@ -0,0 +1,264 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/duty"
+	"github.com/flanksource/duty/context"
+	dutyGorm "github.com/flanksource/duty/gorm"
+	"github.com/glebarez/sqlite"
+	"github.com/samber/oops"
+	"gocloud.dev/pubsub"
+	"gorm.io/gorm"
+)
+
+// Outcomes of a delivery of a message
+const (
+	OutcomeProcessed = "processed"
+	OutcomeFailed    = "failed"
+	OutcomeRetried   = "retried"
+)
+
+// AuditRecord is what happened to a single delivery of a message, a message that is retried has a record
+// for each attempt
+type AuditRecord struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	MessageID string `gorm:"index" json:"messageId"`
+	// Trigger is the namespace/name of the BatchTrigger, or the queue when running without the controller
+	Trigger    string  `gorm:"index" json:"trigger"`
+	Action     string  `json:"action"`
+	Attempt    int     `json:"attempt"`
+	BodySHA256 string  `gorm:"column:body_sha256" json:"bodySha256"`
+	BodySize   int     `json:"bodySize"`
+	Body       *string `json:"body,omitempty"`
+	// Object is the object that was created, e.g. "Job default/sync-1", or what was done by other actions
+	Object  string `json:"object,omitempty"`
+	Outcome string `gorm:"index" json:"outcome"`
+	// Reason is why the delivery failed, Timeout or Error
+	Reason     string    `json:"reason,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ReceivedAt time.Time `gorm:"index" json:"receivedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	DurationMS int64     `json:"durationMs"`
+}
+
+func (AuditRecord) TableName() string {
+	return "batch_runner_audit"
+}
+
+// AuditStore records the messages received by consumers in SQLite or Postgres
+type AuditStore struct {
+	db *gorm.DB
+	// Body stores the full body of each message, otherwise only its hash and size are stored
+	Body bool
+}
+
+// OpenAuditStore opens and migrates the audit table of a database, url is either sqlite://<path> or a
+// postgres:// connection string
+func OpenAuditStore(url string) (*AuditStore, error) {
+	config := duty.DefaultGormConfig()
+	// queries are logged at trace, slow queries and errors are still logged
+	config.Logger = dutyGorm.NewSqlLogger(logger.GetLogger("db")).(*dutyGorm.SqlLogger).WithLogger("db", logger.Trace)
+
+	var db *gorm.DB
+	var err error
+	switch {
+	case strings.HasPrefix(url, "sqlite://"):
+		// sqlite uses the driver of the sql action, as duty only connects to postgres
+		var conn *sql.DB
+		conn, err = sql.Open(sqlTypeSQLite, strings.TrimPrefix(url, "sqlite://"))
+		if err == nil {
+			db, err = gorm.Open(&sqlite.Dialector{DriverName: sqlTypeSQLite, Conn: conn}, config)
+		}
+	case strings.HasPrefix(url, "postgres://"), strings.HasPrefix(url, "postgresql://"):
+		db, err = duty.NewGorm(url, config)
+	default:
+		return nil, fmt.Errorf("unsupported audit database %q, must be sqlite://<path> or postgres://", redactURL(url))
+	}
+	if err != nil {
+		return nil, oops.Wrapf(err, "error opening audit database %s", redactURL(url))
+	}
+	if err := db.AutoMigrate(&AuditRecord{}); err != nil {
+		return nil, oops.Wrapf(err, "error migrating audit database")
+	}
+	return &AuditStore{db: db}, nil
+}
+
+// redactURL removes the password of a connection string
+func redactURL(url string) string {
+	scheme, rest, ok := strings.Cut(url, "://")
+	if !ok {
+		return url
+	}
+	userinfo, host, ok := strings.Cut(rest, "@")
+	if !ok {
+		return url
+	}
+	if user, _, ok := strings.Cut(userinfo, ":"); ok {
+		return scheme + "://" + user + ":***@" + host
+	}
+	return url
+}
+
+// Close closes the connection to the database
+func (s *AuditStore) Close() error {
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// Save writes a record
+func (s *AuditStore) Save(ctx context.Context, record *AuditRecord) error {
+	return s.db.WithContext(ctx).Create(record).Error
+}
+
+// AuditQuery filters the records returned by Query, empty fields match all records
+type AuditQuery struct {
+	Trigger   string
+	MessageID string
+	Outcome   string
+	// Since only returns messages received after it
+	Since time.Time
+	Limit int
+}
+
+// Query returns the records that match q, most recently received first
+func (s *AuditStore) Query(ctx context.Context, q AuditQuery) ([]AuditRecord, error) {
+	tx := s.db.WithContext(ctx).Order("received_at DESC, id DESC")
+	// map conditions are quoted, as trigger is a keyword
+	for column, value := range map[string]string{"trigger": q.Trigger, "message_id": q.MessageID, "outcome": q.Outcome} {
+		if value != "" {
+			tx = tx.Where(map[string]any{column: value})
+		}
+	}
+	if !q.Since.IsZero() {
+		tx = tx.Where("received_at >= ?", q.Since)
+	}
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit)
+	}
+
+	var records []AuditRecord
+	return records, tx.Find(&records).Error
+}
+
+// Prune deletes the records of messages received before a time, returning the number of records deleted
+func (s *AuditStore) Prune(ctx context.Context, before time.Time) (int64, error) {
+	tx := s.db.WithContext(ctx).Where("received_at < ?", before).Delete(&AuditRecord{})
+	return tx.RowsAffected, tx.Error
+}
+
+// RunRetention deletes records older than retention every hour until ctx is done
+func (s *AuditStore) RunRetention(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if deleted, err := s.Prune(ctx, time.Now().Add(-retention)); err != nil {
+			ctx.Errorf("Error pruning audit records: %v", err)
+		} else if deleted > 0 {
+			ctx.Infof("Pruned %d audit records older than %s", deleted, retention)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+var auditStore *AuditStore
+
+// SetAuditStore makes consumers record each message they receive in store, or stops recording if nil
+func SetAuditStore(store *AuditStore) {
+	auditStore = store
+}
+
+// auditMessage returns the record of a received message, or nil if there is no audit store
+func auditMessage(ctx context.Context, config *v1.Config, msg *pubsub.Message, received time.Time) *AuditRecord {
+	if auditStore == nil {
+		return nil
+	}
+	sum := sha256.Sum256(msg.Body)
+	record := &AuditRecord{
+		MessageID:  msg.LoggableID,
+		Action:     strings.Join(configActions(config), ","),
+		Attempt:    retry.Attempt(ctx, msg.LoggableID),
+		BodySHA256: hex.EncodeToString(sum[:]),
+		BodySize:   len(msg.Body),
+		ReceivedAt: received,
+		Trigger:    triggerName(ctx, config),
+	}
+	if auditStore.Body {
+		body := string(msg.Body)
+		record.Body = &body
+	}
+	return record
+}
+
+// callbacks returns callbacks that set the outcome of the record before calling the callbacks of the consumer
+func (r *AuditRecord) callbacks(callbacks *ConsumerCallbacks) *ConsumerCallbacks {
+	if r == nil {
+		return callbacks
+	}
+	if callbacks == nil {
+		callbacks = &ConsumerCallbacks{}
+	}
+	wrapped := *callbacks
+	wrapped.OnMessageProcessed = func() {
+		r.finish(OutcomeProcessed, nil)
+		if callbacks.OnMessageProcessed != nil {
+			callbacks.OnMessageProcessed()
+		}
+	}
+	wrapped.OnMessageFailed = func(err error) {
+		r.finish(OutcomeFailed, err)
+		if callbacks.OnMessageFailed != nil {
+			callbacks.OnMessageFailed(err)
+		}
+	}
+	wrapped.OnMessageRetried = func() {
+		r.finish(OutcomeRetried, nil)
+		if callbacks.OnMessageRetried != nil {
+			callbacks.OnMessageRetried()
+		}
+	}
+	return &wrapped
+}
+
+// finish records the outcome when it is decided, before any delay of a retry
+func (r *AuditRecord) finish(outcome string, err error) {
+	r.Outcome = outcome
+	if err != nil {
+		r.Reason = FailureReason(err)
+		r.Error = err.Error()
+	}
+	r.FinishedAt = time.Now()
+	r.DurationMS = r.FinishedAt.Sub(r.ReceivedAt).Milliseconds()
+}
+
+// save writes the record with the result of the action, errors are logged as the message has already been handled
+func (r *AuditRecord) save(ctx context.Context, result ActionResult, err error) {
+	if r == nil || auditStore == nil {
+		return
+	}
+	r.Object = result.Created
+	if err != nil && r.Error == "" {
+		r.Reason = FailureReason(err)
+		r.Error = err.Error()
+	}
+	if r.Outcome == "" {
+		r.finish(OutcomeFailed, nil)
+	}
+	if err := auditStore.Save(ctx, r); err != nil {
+		ctx.Errorf("Error saving audit record: %v", err)
+	}
+}
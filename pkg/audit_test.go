@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	dutyps "github.com/flanksource/duty/pubsub"
+	. "github.com/onsi/gomega"
+	"gocloud.dev/pubsub"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAuditStore(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	store, err := OpenAuditStore("sqlite://" + filepath.Join(t.TempDir(), "audit.db"))
+	Expect(err).To(BeNil())
+	defer store.Close()
+
+	now := time.Now()
+	for _, r := range []AuditRecord{
+		{MessageID: "1", Trigger: "default/a", Outcome: OutcomeProcessed, ReceivedAt: now.Add(-48 * time.Hour)},
+		{MessageID: "2", Trigger: "default/a", Outcome: OutcomeRetried, ReceivedAt: now.Add(-time.Hour)},
+		{MessageID: "2", Trigger: "default/a", Outcome: OutcomeFailed, ReceivedAt: now.Add(-time.Minute)},
+		{MessageID: "3", Trigger: "default/b", Outcome: OutcomeProcessed, ReceivedAt: now},
+	} {
+		Expect(store.Save(ctx, &r)).To(Succeed())
+	}
+
+	ids := func(q AuditQuery) []string {
+		records, err := store.Query(ctx, q)
+		Expect(err).To(BeNil())
+		var out []string
+		for _, r := range records {
+			out = append(out, r.MessageID+"/"+r.Outcome)
+		}
+		return out
+	}
+
+	t.Run("queries most recent first", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		Expect(ids(AuditQuery{})).To(Equal([]string{"3/processed", "2/failed", "2/retried", "1/processed"}))
+		Expect(ids(AuditQuery{Trigger: "default/a", Limit: 2})).To(Equal([]string{"2/failed", "2/retried"}))
+		Expect(ids(AuditQuery{MessageID: "2", Outcome: OutcomeRetried})).To(Equal([]string{"2/retried"}))
+		Expect(ids(AuditQuery{Since: now.Add(-24 * time.Hour), Outcome: OutcomeProcessed})).To(Equal([]string{"3/processed"}))
+	})
+
+	t.Run("prunes old records", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		deleted, err := store.Prune(ctx, now.Add(-24*time.Hour))
+		Expect(err).To(BeNil())
+		Expect(deleted).To(Equal(int64(1)))
+		Expect(ids(AuditQuery{})).To(HaveLen(3))
+	})
+
+	t.Run("records the outcome of a message", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		SetAuditStore(store)
+		defer SetAuditStore(nil)
+
+		config := &v1.Config{Exec: &v1.ExecAction{}, QueueConfig: dutyps.QueueConfig{Memory: &dutyps.MemoryConfig{QueueName: "audit"}}}
+		msg := &pubsub.Message{LoggableID: "audit-1", Body: []byte(`{"a":"b"}`)}
+		triggerCtx := ctx.WithObject(metav1.ObjectMeta{Name: "sync", Namespace: "jobs"})
+
+		failed := 0
+		record := auditMessage(triggerCtx, config, msg, time.Now())
+		callbacks := record.callbacks(&ConsumerCallbacks{OnMessageRetried: func() { failed++ }})
+		callbacks.OnMessageRetried()
+		record.save(triggerCtx, ActionResult{Created: "script exited with 1"}, errors.New("exit status 1"))
+		Expect(failed).To(Equal(1))
+
+		store.Body = true
+		record = auditMessage(ctx, config, msg, time.Now())
+		record.callbacks(nil).OnMessageProcessed()
+		record.save(ctx, ActionResult{Created: "script exited with 0"}, nil)
+
+		records, err := store.Query(ctx, AuditQuery{MessageID: "audit-1"})
+		Expect(err).To(BeNil())
+		Expect(records).To(HaveLen(2))
+
+		Expect(records[1].Trigger).To(Equal("jobs/sync"))
+		Expect(records[1].Action).To(Equal("exec"))
+		Expect(records[1].Attempt).To(Equal(1))
+		Expect(records[1].Outcome).To(Equal(OutcomeRetried))
+		Expect(records[1].Reason).To(Equal(ReasonError))
+		Expect(records[1].Error).To(Equal("exit status 1"))
+		Expect(records[1].Object).To(Equal("script exited with 1"))
+		Expect(records[1].BodySize).To(Equal(9))
+		Expect(records[1].BodySHA256).To(HaveLen(64))
+		Expect(records[1].Body).To(BeNil())
+		Expect(records[1].FinishedAt).ToNot(BeZero())
+
+		Expect(records[0].Trigger).To(Equal("mem://audit"))
+		Expect(records[0].Outcome).To(Equal(OutcomeProcessed))
+		Expect(records[0].Reason).To(BeEmpty())
+		Expect(records[0].Error).To(BeEmpty())
+		Expect(*records[0].Body).To(Equal(`{"a":"b"}`))
+	})
+}
+
+func TestRedactURL(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(redactURL("postgres://user:secret@db:5432/audit")).To(Equal("postgres://user:***@db:5432/audit"))
+	Expect(redactURL("postgres://db:5432/audit")).To(Equal("postgres://db:5432/audit"))
+	Expect(redactURL("sqlite:///var/lib/audit.db")).To(Equal("sqlite:///var/lib/audit.db"))
+}
@@ -0,0 +1,189 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	"github.com/samber/oops"
+	"gocloud.dev/pubsub"
+)
+
+// FailedMessage is a message that was acked after failing, kept so that it can be replayed
+type FailedMessage struct {
+	MessageID string            `json:"messageId"`
+	Trigger   string            `json:"trigger"`
+	Body      []byte            `json:"body"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	FailedAt  time.Time         `json:"failedAt"`
+
+	// path is the file the message was read from
+	path string
+}
+
+// CaptureStore keeps the most recent failed messages of each trigger as JSON files in a directory
+type CaptureStore struct {
+	dir string
+	// Max is the number of messages kept for each trigger, older messages are deleted
+	Max int
+}
+
+// OpenCaptureStore creates dir if it does not exist
+func OpenCaptureStore(dir string, max int) (*CaptureStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, oops.Wrapf(err, "error creating capture directory %s", dir)
+	}
+	return &CaptureStore{dir: dir, Max: max}, nil
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// triggerDir returns the directory of a trigger, e.g. jobs_sync for jobs/sync or mem_audit for mem://audit
+func (s *CaptureStore) triggerDir(trigger string) string {
+	return filepath.Join(s.dir, unsafePathChars.ReplaceAllString(trigger, "_"))
+}
+
+// Save writes a failed message and deletes the oldest messages of its trigger above Max
+func (s *CaptureStore) Save(m *FailedMessage) error {
+	dir := s.triggerDir(m.Trigger)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	// names sort by the time of failure, the hash keeps deliveries of different messages at the same time apart
+	sum := sha256.Sum256([]byte(m.MessageID))
+	name := fmt.Sprintf("%020d-%s.json", m.FailedAt.UnixNano(), hex.EncodeToString(sum[:4]))
+	tmp := filepath.Join(dir, "."+name)
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	m.path = filepath.Join(dir, name)
+
+	if s.Max <= 0 {
+		return nil
+	}
+	files, err := s.files(dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > s.Max {
+		if err := os.Remove(files[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// files returns the messages of a trigger directory, oldest first
+func (s *CaptureStore) files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") && strings.HasSuffix(e.Name(), ".json") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// List returns the failed messages of a trigger that failed after since, oldest first
+func (s *CaptureStore) List(trigger string, since time.Time) ([]FailedMessage, error) {
+	files, err := s.files(s.triggerDir(trigger))
+	if err != nil {
+		return nil, oops.Wrapf(err, "error listing failed messages of %s", trigger)
+	}
+	var messages []FailedMessage
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			// deleted by a consumer that captured a newer message
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var m FailedMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, oops.Wrapf(err, "error reading %s", file)
+		}
+		if m.Trigger != trigger || m.FailedAt.Before(since) {
+			continue
+		}
+		m.path = file
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+// Remove deletes a message returned by List, e.g. once it has been replayed
+func (s *CaptureStore) Remove(m FailedMessage) error {
+	if m.path == "" {
+		return nil
+	}
+	if err := os.Remove(m.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+var captureStore *CaptureStore
+
+// SetCaptureStore makes consumers keep the messages that fail in store, or stops keeping them if nil
+func SetCaptureStore(store *CaptureStore) {
+	captureStore = store
+}
+
+// captureFailed returns callbacks that keep msg in the capture store when it fails, before calling the
+// callbacks of the consumer
+func captureFailed(ctx context.Context, config *v1.Config, msg *pubsub.Message, callbacks *ConsumerCallbacks) *ConsumerCallbacks {
+	if captureStore == nil {
+		return callbacks
+	}
+	if callbacks == nil {
+		callbacks = &ConsumerCallbacks{}
+	}
+	wrapped := *callbacks
+	wrapped.OnMessageFailed = func(err error) {
+		failed := &FailedMessage{
+			MessageID: msg.LoggableID,
+			Trigger:   triggerName(ctx, config),
+			Body:      msg.Body,
+			Metadata:  maps.Clone(msg.Metadata),
+			FailedAt:  time.Now(),
+		}
+		if err != nil {
+			failed.Error = err.Error()
+		}
+		if err := captureStore.Save(failed); err != nil {
+			ctx.Errorf("Error capturing failed message: %v", err)
+		}
+		if callbacks.OnMessageFailed != nil {
+			callbacks.OnMessageFailed(err)
+		}
+	}
+	return &wrapped
+}
@@ -0,0 +1,178 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	dutyps "github.com/flanksource/duty/pubsub"
+	. "github.com/onsi/gomega"
+	"gocloud.dev/pubsub"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCaptureStore(t *testing.T) {
+	RegisterTestingT(t)
+
+	store, err := OpenCaptureStore(t.TempDir(), 3)
+	Expect(err).To(BeNil())
+
+	now := time.Now()
+	for i := 1; i <= 5; i++ {
+		Expect(store.Save(&FailedMessage{
+			MessageID: fmt.Sprint(i),
+			Trigger:   "jobs/sync",
+			Body:      []byte(fmt.Sprintf(`{"n":%d}`, i)),
+			FailedAt:  now.Add(time.Duration(i-5) * time.Hour),
+		})).To(Succeed())
+	}
+	Expect(store.Save(&FailedMessage{MessageID: "other", Trigger: "jobs/other", FailedAt: now})).To(Succeed())
+
+	ids := func(trigger string, since time.Time) []string {
+		messages, err := store.List(trigger, since)
+		Expect(err).To(BeNil())
+		var out []string
+		for _, m := range messages {
+			out = append(out, m.MessageID)
+		}
+		return out
+	}
+
+	t.Run("keeps the most recent messages of each trigger", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		Expect(ids("jobs/sync", time.Time{})).To(Equal([]string{"3", "4", "5"}))
+		Expect(ids("jobs/other", time.Time{})).To(Equal([]string{"other"}))
+		Expect(ids("jobs/missing", time.Time{})).To(BeEmpty())
+	})
+
+	t.Run("lists messages that failed since a time", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		Expect(ids("jobs/sync", now.Add(-90*time.Minute))).To(Equal([]string{"4", "5"}))
+	})
+
+	t.Run("captures messages that fail", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		SetCaptureStore(store)
+		defer SetCaptureStore(nil)
+
+		config := &v1.Config{QueueConfig: dutyps.QueueConfig{Memory: &dutyps.MemoryConfig{QueueName: "capture"}}}
+		msg := &pubsub.Message{LoggableID: "capture-1", Body: []byte(`{"a":"b"}`), Metadata: map[string]string{"k": "v"}}
+		triggerCtx := context.New().WithObject(metav1.ObjectMeta{Name: "capture", Namespace: "jobs"})
+
+		failed := 0
+		callbacks := captureFailed(triggerCtx, config, msg, &ConsumerCallbacks{OnMessageFailed: func(error) { failed++ }})
+		callbacks.OnMessageFailed(errors.New("template: no such key"))
+		Expect(failed).To(Equal(1))
+
+		messages, err := store.List("jobs/capture", time.Time{})
+		Expect(err).To(BeNil())
+		Expect(messages).To(HaveLen(1))
+		Expect(messages[0].MessageID).To(Equal("capture-1"))
+		Expect(string(messages[0].Body)).To(Equal(`{"a":"b"}`))
+		Expect(messages[0].Metadata).To(Equal(map[string]string{"k": "v"}))
+		Expect(messages[0].Error).To(Equal("template: no such key"))
+	})
+}
+
+func TestReplayMessages(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	source := dutyps.QueueConfig{Memory: &dutyps.MemoryConfig{QueueName: "replay-source"}}
+	destination := dutyps.QueueConfig{Memory: &dutyps.MemoryConfig{QueueName: "replay-destination"}}
+	config := &v1.Config{
+		QueueConfig: source,
+		Publish:     &v1.PublishAction{Body: "{{.n}}", QueueConfig: destination},
+	}
+
+	receive := func(queue dutyps.QueueConfig) *pubsub.Subscription {
+		// memory subscriptions can only be opened once the topic exists
+		topic, err := OpenTopic(ctx, queue, v1.TopicConfig{})
+		Expect(err).To(BeNil())
+		t.Cleanup(func() { _ = topic.Shutdown(ctx) })
+		sub, err := pubsub.OpenSubscription(ctx, "mem://"+queue.Memory.QueueName)
+		Expect(err).To(BeNil())
+		t.Cleanup(func() { _ = sub.Shutdown(ctx) })
+		return sub
+	}
+
+	// memory subscriptions do not keep the order of messages
+	bodies := func(sub *pubsub.Subscription) []string {
+		var out []string
+		for i := 0; i < 2; i++ {
+			msg, err := sub.Receive(ctx)
+			Expect(err).To(BeNil())
+			Expect(msg.Metadata).To(Equal(map[string]string{"k": "v"}))
+			out = append(out, string(msg.Body))
+			msg.Ack()
+		}
+		return out
+	}
+
+	capture := func(store *CaptureStore) {
+		for i, body := range []string{`{"n":1}`, `{"n":2}`} {
+			Expect(store.Save(&FailedMessage{
+				MessageID: fmt.Sprint(i + 1),
+				Trigger:   "jobs/replay",
+				Body:      []byte(body),
+				Metadata:  map[string]string{"k": "v"},
+				FailedAt:  time.Now(),
+			})).To(Succeed())
+		}
+	}
+
+	t.Run("sends messages to the queue of the trigger", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		store, err := OpenCaptureStore(t.TempDir(), 0)
+		Expect(err).To(BeNil())
+		capture(store)
+		sub := receive(source)
+
+		start := time.Now()
+		replayed, failed, err := ReplayMessages(ctx, store, config, "jobs/replay", ReplayOptions{Target: ReplayToQueue, Rate: 10})
+		Expect(err).To(BeNil())
+		Expect(replayed).To(Equal(2))
+		Expect(failed).To(Equal(0))
+		Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond))
+
+		Expect(bodies(sub)).To(ConsistOf(`{"n":1}`, `{"n":2}`))
+
+		messages, err := store.List("jobs/replay", time.Time{})
+		Expect(err).To(BeNil())
+		Expect(messages).To(BeEmpty())
+	})
+
+	t.Run("runs messages through the action", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		store, err := OpenCaptureStore(t.TempDir(), 0)
+		Expect(err).To(BeNil())
+		capture(store)
+		sub := receive(destination)
+
+		replayed, failed, err := ReplayMessages(ctx, store, config, "jobs/replay", ReplayOptions{Target: ReplayToAction})
+		Expect(err).To(BeNil())
+		Expect(replayed).To(Equal(2))
+		Expect(failed).To(Equal(0))
+
+		Expect(bodies(sub)).To(ConsistOf("1", "2"))
+	})
+
+	t.Run("rejects unknown targets", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		store, err := OpenCaptureStore(t.TempDir(), 0)
+		Expect(err).To(BeNil())
+		capture(store)
+
+		_, _, err = ReplayMessages(ctx, store, config, "jobs/replay", ReplayOptions{Target: "dlq"})
+		Expect(err).To(MatchError(ContainSubstring(`unknown replay target "dlq"`)))
+	})
+}
@@ -0,0 +1,198 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/commons/logger"
+	yamlv3 "gopkg.in/yaml.v3"
+	kjson "sigs.k8s.io/json"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigError is a problem with a config file, Line is the line of the field in the file or of the start
+// of the document when the field is not known
+type ConfigError struct {
+	File    string
+	Line    int
+	Path    string
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Path, e.Message)
+}
+
+// configDocument is a single document of a config file
+type configDocument struct {
+	file string
+	// line is the line of the file the document starts on
+	line int
+	data []byte
+	root *yamlv3.Node
+}
+
+// errorf returns an error for the field at path, e.g. job.spec.template.spec.containers[0].image
+func (d configDocument) errorf(path, format string, args ...any) ConfigError {
+	line := d.line
+	if d.root != nil {
+		line += nodeLine(d.root, path) - 1
+	}
+	return ConfigError{File: d.file, Line: line, Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+var documentSeparator = regexp.MustCompile(`(?m)^---\n`)
+
+// readConfigDocuments returns the documents of a config file, which are separated by ---
+func readConfigDocuments(file string) ([]configDocument, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %v", file, err)
+	}
+
+	var docs []configDocument
+	start := 0
+	separators := append(documentSeparator.FindAllIndex(data, -1), []int{len(data), len(data)})
+	for _, sep := range separators {
+		chunk := data[start:sep[0]]
+		if strings.TrimSpace(string(chunk)) != "" {
+			docs = append(docs, configDocument{file: file, line: bytes.Count(data[:start], []byte("\n")) + 1, data: chunk})
+		}
+		start = sep[1]
+	}
+	return docs, nil
+}
+
+var (
+	yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+	typeErrorPath = regexp.MustCompile(`Go struct field \w+\.(\S+) of type`)
+)
+
+// parseConfig parses a document, returning an error for each unknown or duplicate field
+func parseConfig(doc *configDocument) (*v1.Config, []ConfigError) {
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(doc.data, &root); err != nil {
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return nil, []ConfigError{{File: doc.file, Line: doc.line + line - 1, Message: m[2]}}
+		}
+		return nil, []ConfigError{doc.errorf("", "%v", err)}
+	}
+	doc.root = &root
+
+	data, err := yaml.YAMLToJSON(doc.data)
+	if err != nil {
+		return nil, []ConfigError{doc.errorf("", "%v", err)}
+	}
+
+	var config v1.Config
+	strict, err := kjson.UnmarshalStrict(data, &config, kjson.DisallowDuplicateFields, kjson.DisallowUnknownFields)
+	if err != nil {
+		path := ""
+		if m := typeErrorPath.FindStringSubmatch(err.Error()); m != nil {
+			path = m[1]
+		}
+		return nil, []ConfigError{doc.errorf(path, "%v", err)}
+	}
+
+	var errs []ConfigError
+	for _, e := range strict {
+		var field kjson.FieldError
+		if errors.As(e, &field) {
+			errs = append(errs, doc.errorf(field.FieldPath(), "%s", strings.TrimSuffix(e.Error(), fmt.Sprintf(" %q", field.FieldPath()))))
+		} else {
+			errs = append(errs, doc.errorf("", "%v", e))
+		}
+	}
+	return &config, errs
+}
+
+// nodeLine returns the line of the field at path in a YAML document, or of its closest parent that exists.
+// Keys containing dots, e.g. labels, are matched before shorter keys.
+func nodeLine(node *yamlv3.Node, path string) int {
+	if node.Kind == yamlv3.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+	for path != "" {
+		switch {
+		case strings.HasPrefix(path, "["):
+			end := strings.Index(path, "]")
+			if node.Kind != yamlv3.SequenceNode || end < 0 {
+				return line
+			}
+			i, err := strconv.Atoi(path[1:end])
+			if err != nil || i >= len(node.Content) {
+				return line
+			}
+			node = node.Content[i]
+			line = node.Line
+			path = strings.TrimPrefix(path[end+1:], ".")
+		case node.Kind == yamlv3.MappingNode:
+			var key, value *yamlv3.Node
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				k := node.Content[i]
+				if (path == k.Value || strings.HasPrefix(path, k.Value+".") || strings.HasPrefix(path, k.Value+"[")) &&
+					(key == nil || len(k.Value) > len(key.Value)) {
+					key, value = k, node.Content[i+1]
+				}
+			}
+			if key == nil {
+				return line
+			}
+			node = value
+			line = key.Line
+			path = strings.TrimPrefix(path[len(key.Value):], ".")
+		default:
+			return line
+		}
+	}
+	return line
+}
+
+// ParseConfigFiles returns the configs in each file, which may contain multiple documents separated by ---.
+// Unknown and duplicate fields are logged as warnings and field names are matched case-insensitively, as
+// they always have been, use ValidateConfigFiles to reject them and to also check actions, queues and templates.
+func ParseConfigFiles(configFiles []string) ([]v1.Config, error) {
+
+	var configs []v1.Config
+
+	for _, configFile := range configFiles {
+		docs, err := readConfigDocuments(configFile)
+		if err != nil {
+			return nil, err
+		}
+		for i := range docs {
+			strict, errs := parseConfig(&docs[i])
+			if strict == nil {
+				return nil, joinConfigErrors(errs)
+			}
+			for _, e := range errs {
+				logger.Warnf("%v", e)
+			}
+			var config v1.Config
+			if err := yaml.Unmarshal(docs[i].data, &config); err != nil {
+				return nil, docs[i].errorf("", "%v", err)
+			}
+			configs = append(configs, config)
+		}
+	}
+	return configs, nil
+}
+
+func joinConfigErrors(errs []ConfigError) error {
+	all := make([]error, len(errs))
+	for i := range errs {
+		all[i] = errs[i]
+	}
+	return errors.Join(all...)
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	gocontext "context"
+	"fmt"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+// replaceTimeout is how long to wait for an existing object to be deleted before recreating it
+var replaceTimeout = 2 * time.Minute
+
+// objectClient is the subset of the typed and dynamic clients needed to resolve name conflicts
+type objectClient[T metav1.Object] interface {
+	Create(ctx gocontext.Context, obj T, opts metav1.CreateOptions) (T, error)
+	Get(ctx gocontext.Context, name string, opts metav1.GetOptions) (T, error)
+	Delete(ctx gocontext.Context, name string, opts metav1.DeleteOptions) error
+}
+
+// dynamicObjectClient adapts a dynamic.ResourceInterface to objectClient
+type dynamicObjectClient struct {
+	dynamic.ResourceInterface
+}
+
+func (c dynamicObjectClient) Create(ctx gocontext.Context, obj *unstructured.Unstructured, opts metav1.CreateOptions) (*unstructured.Unstructured, error) {
+	return c.ResourceInterface.Create(ctx, obj, opts)
+}
+
+func (c dynamicObjectClient) Get(ctx gocontext.Context, name string, opts metav1.GetOptions) (*unstructured.Unstructured, error) {
+	return c.ResourceInterface.Get(ctx, name, opts)
+}
+
+func (c dynamicObjectClient) Delete(ctx gocontext.Context, name string, opts metav1.DeleteOptions) error {
+	return c.ResourceInterface.Delete(ctx, name, opts)
+}
+
+// createWithPolicy creates obj, resolving AlreadyExists errors according to policy.
+// The returned bool is false when an existing object was reused rather than created.
+func createWithPolicy[T metav1.Object](ctx context.Context, c objectClient[T], obj T, policy v1.ConflictPolicy) (T, bool, error) {
+	created, err := c.Create(ctx, obj, metav1.CreateOptions{})
+	if err == nil || !kerrors.IsAlreadyExists(err) {
+		return created, err == nil, err
+	}
+
+	name := obj.GetName()
+	switch policy {
+	case v1.ConflictSkip:
+		existing, err := c.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return existing, false, err
+		}
+		ctx.Infof("%s/%s already exists, skipping", obj.GetNamespace(), name)
+		return existing, false, nil
+
+	case v1.ConflictReplace:
+		ctx.Infof("%s/%s already exists, replacing", obj.GetNamespace(), name)
+		propagation := metav1.DeletePropagationBackground
+		if err := c.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil && !kerrors.IsNotFound(err) {
+			return created, false, err
+		}
+		if err := wait.PollUntilContextTimeout(ctx, time.Second, replaceTimeout, true, func(pollCtx gocontext.Context) (bool, error) {
+			_, err := c.Get(pollCtx, name, metav1.GetOptions{})
+			return kerrors.IsNotFound(err), nil
+		}); err != nil {
+			return created, false, fmt.Errorf("timed out waiting for %s/%s to be deleted: %w", obj.GetNamespace(), name, err)
+		}
+		created, err = c.Create(ctx, obj, metav1.CreateOptions{})
+		return created, err == nil, err
+
+	case v1.ConflictSuffix:
+		obj.SetName(fmt.Sprintf("%s-%s", name, rand.String(5)))
+		ctx.Infof("%s/%s already exists, creating %s", obj.GetNamespace(), name, obj.GetName())
+		created, err = c.Create(ctx, obj, metav1.CreateOptions{})
+		return created, err == nil, err
+	}
+
+	return created, false, err
+}
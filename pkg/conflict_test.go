@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"testing"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCreateWithPolicy(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+
+	existing := func() *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "batch-a", Namespace: "default", Labels: map[string]string{"version": "1"}},
+		}
+	}
+	updated := func() *corev1.Pod {
+		pod := existing()
+		pod.Labels["version"] = "2"
+		return pod
+	}
+
+	t.Run("fail returns AlreadyExists", func(t *testing.T) {
+		RegisterTestingT(t)
+		pods := fake.NewClientset(existing()).CoreV1().Pods("default")
+
+		_, created, err := createWithPolicy(ctx, pods, updated(), v1.ConflictFail)
+		Expect(kerrors.IsAlreadyExists(err)).To(BeTrue())
+		Expect(created).To(BeFalse())
+	})
+
+	t.Run("skip returns the existing object", func(t *testing.T) {
+		RegisterTestingT(t)
+		pods := fake.NewClientset(existing()).CoreV1().Pods("default")
+
+		pod, created, err := createWithPolicy(ctx, pods, updated(), v1.ConflictSkip)
+		Expect(err).To(BeNil())
+		Expect(created).To(BeFalse())
+		Expect(pod.Labels["version"]).To(Equal("1"))
+	})
+
+	t.Run("replace recreates the object", func(t *testing.T) {
+		RegisterTestingT(t)
+		pods := fake.NewClientset(existing()).CoreV1().Pods("default")
+
+		pod, created, err := createWithPolicy(ctx, pods, updated(), v1.ConflictReplace)
+		Expect(err).To(BeNil())
+		Expect(created).To(BeTrue())
+		Expect(pod.Name).To(Equal("batch-a"))
+		Expect(pod.Labels["version"]).To(Equal("2"))
+	})
+
+	t.Run("suffix creates a new object", func(t *testing.T) {
+		RegisterTestingT(t)
+		pods := fake.NewClientset(existing()).CoreV1().Pods("default")
+
+		pod, created, err := createWithPolicy(ctx, pods, updated(), v1.ConflictSuffix)
+		Expect(err).To(BeNil())
+		Expect(created).To(BeTrue())
+		Expect(pod.Name).To(HavePrefix("batch-a-"))
+		Expect(pod.Name).To(HaveLen(len("batch-a-") + 5))
+	})
+}
@@ -2,22 +2,23 @@ package pkg
 
 import (
 	gocontext "context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/yaml"
 
+	"github.com/flanksource/artifacts"
 	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
 	"github.com/flanksource/commons/logger"
 	"github.com/flanksource/duty/context"
+	dutyKubernetes "github.com/flanksource/duty/kubernetes"
 	dutyps "github.com/flanksource/duty/pubsub"
-	"github.com/flanksource/duty/shell"
-	"github.com/flanksource/gomplate/v3"
 	"github.com/samber/lo"
 	"github.com/samber/oops"
 
@@ -38,6 +39,10 @@ type ConsumerCallbacks struct {
 	OnMessageFailed    func(err error)
 	OnMessageRetried   func()
 	OnConnectionChange func(state string)
+	// OnArtifactsUploaded is called with the URLs of the artifacts uploaded to the artifact store
+	OnArtifactsUploaded func(urls []string)
+	// OnEvent is called with failures and created workloads that are worth recording as Kubernetes Events
+	OnEvent func(event Event)
 }
 
 func pretty(o any) string {
@@ -64,16 +69,43 @@ func RunConsumerWithCallbacks(rootCtx context.Context, config *v1.Config, callba
 
 	rootCtx.Tracef("Config: \n%+v", pretty(config))
 
+	if config.GetDestination() == nil {
+		return errNoAction
+	}
+	if _, err := templateDelims(config.Template); err != nil {
+		return oops.Wrapf(err, "Invalid config")
+	}
+
+	metrics := newConsumerMetrics(triggerName(rootCtx, config))
+	callbacks = metrics.callbacks(callbacks)
+	defer metrics.setConnectionState(ConnectionDisconnected)
+
 	sub, err := dutyps.Subscribe(rootCtx, config.QueueConfig)
 	if err != nil {
 		if callbacks != nil && callbacks.OnConnectionChange != nil {
-			callbacks.OnConnectionChange("Error")
+			callbacks.OnConnectionChange(ConnectionError)
 		}
 		return oops.Wrapf(err, "Error building URL")
 	}
 
 	if callbacks != nil && callbacks.OnConnectionChange != nil {
-		callbacks.OnConnectionChange("Connected")
+		callbacks.OnConnectionChange(ConnectionConnected)
+	}
+
+	var topic *pubsub.Topic
+	if config.Publish != nil {
+		topic, err = OpenTopic(rootCtx, config.Publish.QueueConfig, config.Publish.TopicConfig)
+		if err != nil {
+			if callbacks != nil && callbacks.OnConnectionChange != nil {
+				callbacks.OnConnectionChange(ConnectionError)
+			}
+			return oops.Wrapf(err, "Error opening %s", config.Publish)
+		}
+		defer func() {
+			if err := topic.Shutdown(gocontext.Background()); err != nil {
+				rootCtx.Errorf("Error closing %s: %v", config.Publish, err)
+			}
+		}()
 	}
 
 	rootCtx.Infof("Consuming from %s", config.String())
@@ -97,6 +129,7 @@ func RunConsumerWithCallbacks(rootCtx context.Context, config *v1.Config, callba
 			continue
 		}
 
+		received := time.Now()
 		ctx := rootCtx.WithName(lo.CoalesceOrEmpty(msg.LoggableID, "unknown"))
 		ctx.Logger.SetLogLevel(config.LogLevel)
 
@@ -105,141 +138,136 @@ func RunConsumerWithCallbacks(rootCtx context.Context, config *v1.Config, callba
 			return oops.Wrapf(err, "Error getting Kubernetes client")
 		}
 
-		// Attempt to decode Base64
-		decoded, err := base64.StdEncoding.DecodeString(string(msg.Body))
-		if err != nil {
-			decoded = msg.Body
-		}
+		ctx, span := startReceiveSpan(ctx, metrics.trigger, msg)
+		handled := metrics.received(msg)
+		record := auditMessage(ctx, config, msg, received)
+		messageCallbacks := captureFailed(ctx, config, msg, record.callbacks(callbacks))
+		result, err := handleMessage(ctx, client, config, msg, received, topic, messageCallbacks, metrics)
+		record.save(ctx, result, err)
+		handled(result)
+		endSpan(span, err)
+	}
+}
 
-		// Attempt to unmarshal to map
-		var data map[string]any
-		if err := json.Unmarshal(decoded, &data); err != nil {
-			ctx.Tracef("Error unmarshalling message to json: %v", err)
-			data = map[string]any{"body": string(decoded)}
+// handleMessage renders and runs the action of a message, and then acks, retries or fails it
+func handleMessage(ctx context.Context, client *dutyKubernetes.Client, config *v1.Config, msg *pubsub.Message, received time.Time, topic *pubsub.Topic, callbacks *ConsumerCallbacks, metrics *consumerMetrics) (ActionResult, error) {
+	lookup := NewClusterLookup(ctx, client, lo.CoalesceOrEmpty(ctx.GetNamespace(), "default"))
+	start := time.Now()
+	rendered, err := Render(ctx, config, msg, received, lookup)
+	if err != nil {
+		ctx.Errorf("Error rendering message: %v", err)
+		warningEvent(callbacks, ReasonTemplateFailed, "Error rendering message %s: %v", msg.LoggableID, err)
+		if callbacks != nil && callbacks.OnMessageFailed != nil {
+			callbacks.OnMessageFailed(err)
 		}
-		data["_raw_body"] = string(msg.Body)
-		data["_id"] = msg.LoggableID
-		data["_metadata"] = msg.Metadata
-
-		ctx.Debugf("Received message:\n %+v", pretty(data))
-
-		templater := gomplate.StructTemplater{
-			Values:         data,
-			DelimSets:      []gomplate.Delims{{Left: "{{", Right: "}}"}},
-			ValueFunctions: true,
+		msg.Ack()
+		return ActionResult{}, err
+	}
+	metrics.observeRender(rendered, time.Since(start))
+	ctx.Tracef("rendered=%s", pretty(rendered))
+
+	start = time.Now()
+	result, err := RunAction(ctx, client, config, rendered, topic, callbacks)
+	metrics.observeAction(time.Since(start))
+	switch {
+	case result.Skipped && err == nil:
+		skipped(ctx, msg, result.Object, callbacks)
+	case result.Object != nil:
+		shouldRetryWithCallbacks(ctx, msg, result.Object, err, callbacks)
+	case err == nil:
+		retry.Remove(ctx, msg.LoggableID)
+		if rendered.Exec != nil {
+			emitEvent(callbacks, completedEvent(msg.LoggableID, result))
 		}
+		if callbacks != nil && callbacks.OnMessageProcessed != nil {
+			callbacks.OnMessageProcessed()
+		}
+		msg.Ack()
+	case result.Permanent:
+		warningEvent(callbacks, failureEventReason(err, ReasonActionFailed), "Message %s failed: %s", msg.LoggableID, FailureMessage(err))
+		if callbacks != nil && callbacks.OnMessageFailed != nil {
+			callbacks.OnMessageFailed(err)
+		}
+		msg.Ack()
+	default:
+		retryOrFail(ctx, msg, result.Retry, err, callbacks)
+	}
+	return result, err
+}
 
-		if config.Pod != nil {
-			var pod = config.Pod.DeepCopy()
-
-			if err := templater.Walk(&pod); err != nil {
-				ctx.Errorf("Error templating Pod: %v", err)
-				if callbacks != nil && callbacks.OnMessageFailed != nil {
-					callbacks.OnMessageFailed(err)
-				}
-				msg.Ack()
-				continue
-			}
-
-			ctx.Tracef("pod=%s", pretty(pod))
-
-			p, err := client.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
-			if p == nil || p.CreationTimestamp.IsZero() {
-				p = pod
-			}
-			shouldRetryWithCallbacks(ctx, msg, p, err, callbacks)
-		} else if config.Job != nil {
-			var job = config.Job.DeepCopy()
-
-			if err := templater.Walk(job); err != nil {
-				ctx.Errorf("Error templating job: %v", err)
-				if callbacks != nil && callbacks.OnMessageFailed != nil {
-					callbacks.OnMessageFailed(err)
-				}
-				msg.Ack()
-				continue
-			}
-
-			ctx.Tracef("job=%s", pretty(job))
-
-			created, err := client.BatchV1().Jobs(job.Namespace).Create(ctx, job, metav1.CreateOptions{})
-			if created.CreationTimestamp.IsZero() {
-				created = job
-			}
-
-			shouldRetryWithCallbacks(ctx, msg, created, err, callbacks)
-		} else if config.Exec != nil {
-			exec := *config.Exec
-			if err := templater.Walk(&exec); err != nil {
-				ctx.Errorf("Error templating exec: %v", err)
-				if callbacks != nil && callbacks.OnMessageFailed != nil {
-					callbacks.OnMessageFailed(err)
-				}
-				msg.Ack()
-				continue
-			}
-
-			ctx.Tracef("job=%s", pretty(exec))
-
-			details, err := shell.Run(ctx, exec.ToShellExec())
-			if err == nil && details.ExitCode == 0 {
-				ctx.Tracef("%s", details.String())
-				if callbacks != nil && callbacks.OnMessageProcessed != nil {
-					callbacks.OnMessageProcessed()
-				}
-				msg.Ack()
-				continue
-			}
-
-			if exec.Retry == nil {
-				exec.Retry = &v1.Retry{
-					Attempts: 3,
-					Delay:    30,
-				}
-			}
-
-			execErr := err
-			if execErr == nil {
-				execErr = fmt.Errorf("script returned non-zero exit code: %s", details)
-			}
-
-			if err != nil {
-				ctx.Errorf("Error running %s: %s\n%s", exec.Script, err, details)
-			} else {
-				ctx.Errorf("Script returned non-zero exit code: %s", details)
-			}
-
-			delay := retry.GetBackoff(ctx, msg.LoggableID, exec.Retry)
-			if delay != nil {
-				if callbacks != nil && callbacks.OnMessageRetried != nil {
-					callbacks.OnMessageRetried()
-				}
-				if msg.Nackable() {
-					msg.Nack()
-				}
-				time.Sleep(*delay)
-			} else {
-				if callbacks != nil && callbacks.OnMessageFailed != nil {
-					callbacks.OnMessageFailed(execErr)
-				}
-				msg.Ack()
-			}
+// uploadExecArtifacts uploads artifacts to the store and returns their URLs, failures are logged without
+// failing the message as the script has already run
+func uploadExecArtifacts(ctx context.Context, store v1.ArtifactStore, templater Templater, provenance Provenance, list []artifacts.Artifact, callbacks *ConsumerCallbacks) []string {
+	prefix, err := artifactPrefix(store, templater, provenance)
+	if err != nil {
+		ctx.Errorf("Error templating artifact prefix: %v", err)
+		return nil
+	}
+	urls, err := uploadArtifacts(ctx, store, prefix, list)
+	if err != nil {
+		ctx.Errorf("Error uploading artifacts: %v", err)
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	ctx.Infof("Uploaded artifacts: %s", strings.Join(urls, ", "))
+	if callbacks != nil && callbacks.OnArtifactsUploaded != nil {
+		callbacks.OnArtifactsUploaded(urls)
+	}
+	return urls
+}
 
-		} else {
-			return fmt.Errorf("Invalid config, must specify either a job or a pod")
+// retryOrFail redelivers msg after the configured delay, or fails it once the retry attempts are exhausted
+func retryOrFail(ctx context.Context, msg *pubsub.Message, r *v1.Retry, err error, callbacks *ConsumerCallbacks) {
+	delay := retry.GetBackoff(ctx, msg.LoggableID, r)
+	if delay != nil {
+		if callbacks != nil && callbacks.OnMessageRetried != nil {
+			callbacks.OnMessageRetried()
 		}
+		if msg.Nackable() {
+			msg.Nack()
+		}
+		time.Sleep(*delay)
+		return
 	}
+	warningEvent(callbacks, failureEventReason(err, ReasonRetriesExhausted), "Message %s failed after exhausting retries: %s", msg.LoggableID, FailureMessage(err))
+	if callbacks != nil && callbacks.OnMessageFailed != nil {
+		callbacks.OnMessageFailed(err)
+	}
+	msg.Ack()
+}
+
+func shouldRetry(ctx context.Context, msg *pubsub.Message, o metav1.Object, err error) {
+	shouldRetryWithCallbacks(ctx, msg, o, err, nil)
 }
 
-func shouldRetry(ctx context.Context, msg *pubsub.Message, accessor metav1.ObjectMetaAccessor, err error) {
-	shouldRetryWithCallbacks(ctx, msg, accessor, err, nil)
+// skipped acks a message whose object already existed and was kept by the skip conflict policy
+func skipped(ctx context.Context, msg *pubsub.Message, o metav1.Object, callbacks *ConsumerCallbacks) {
+	retry.Remove(ctx, msg.LoggableID)
+	ctx.Infof("Skipped %s/%s as it already exists", o.GetNamespace(), o.GetName())
+	emitEvent(callbacks, Event{
+		Type:    corev1.EventTypeNormal,
+		Reason:  ReasonSkipped,
+		Message: fmt.Sprintf("Kept existing %s/%s for message %s", o.GetNamespace(), o.GetName(), msg.LoggableID),
+		Object:  o,
+	})
+	if callbacks != nil && callbacks.OnMessageProcessed != nil {
+		callbacks.OnMessageProcessed()
+	}
+	msg.Ack()
 }
 
-func shouldRetryWithCallbacks(ctx context.Context, msg *pubsub.Message, accessor metav1.ObjectMetaAccessor, err error, callbacks *ConsumerCallbacks) {
-	o := accessor.GetObjectMeta()
+func shouldRetryWithCallbacks(ctx context.Context, msg *pubsub.Message, o metav1.Object, err error, callbacks *ConsumerCallbacks) {
 	name := fmt.Sprintf("%s/%s (uid=%s)", o.GetNamespace(), o.GetName(), o.GetUID())
 	if err == nil {
+		retry.Remove(ctx, msg.LoggableID)
 		ctx.Infof("Created %s", name)
+		emitEvent(callbacks, Event{
+			Type:    corev1.EventTypeNormal,
+			Reason:  ReasonCreated,
+			Message: fmt.Sprintf("Created %s/%s for message %s", o.GetNamespace(), o.GetName(), msg.LoggableID),
+			Object:  o,
+		})
 		if callbacks != nil && callbacks.OnMessageProcessed != nil {
 			callbacks.OnMessageProcessed()
 		}
@@ -247,7 +275,9 @@ func shouldRetryWithCallbacks(ctx context.Context, msg *pubsub.Message, accessor
 		return
 	}
 	if !IsRetryableError(err) {
-		ctx.Errorf("Unretryable error creating: %v\n%s", err, pretty(accessor))
+		retry.Remove(ctx, msg.LoggableID)
+		ctx.Errorf("Unretryable error creating: %v\n%s", err, pretty(o))
+		warningEvent(callbacks, ReasonCreateFailed, "Error creating %s/%s for message %s: %v", o.GetNamespace(), o.GetName(), msg.LoggableID, err)
 		if callbacks != nil && callbacks.OnMessageFailed != nil {
 			callbacks.OnMessageFailed(err)
 		}
@@ -258,12 +288,13 @@ func shouldRetryWithCallbacks(ctx context.Context, msg *pubsub.Message, accessor
 	if delay, ok := kerrors.SuggestsClientDelay(err); ok {
 		_delay = time.Duration(delay)
 	}
+	retry.RecordAttempt(ctx, msg.LoggableID)
 	if callbacks != nil && callbacks.OnMessageRetried != nil {
 		callbacks.OnMessageRetried()
 	}
 	if msg.Nackable() {
 		msg.Nack()
 	}
-	ctx.Errorf("Error creating, (retrying in %s %v\n%s", _delay, err, pretty(accessor))
+	ctx.Errorf("Error creating, (retrying in %s %v\n%s", _delay, err, pretty(o))
 	time.Sleep(_delay)
 }
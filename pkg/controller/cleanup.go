@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/flanksource/batch-runner/pkg"
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// FinalizerOrphan is added to triggers with an Orphan deletion policy, so that owner references
+// can be removed from created workloads before the trigger is garbage collected
+const FinalizerOrphan = v1.Group + "/orphan"
+
+func triggerLabels(trigger *v1.BatchTrigger) client.MatchingLabels {
+	return client.MatchingLabels{
+		pkg.LabelTrigger:          trigger.Name,
+		pkg.LabelTriggerNamespace: trigger.Namespace,
+	}
+}
+
+func wantsOrphanFinalizer(trigger *v1.BatchTrigger) bool {
+	return trigger.Spec.OwnerReference && trigger.Spec.DeletionPolicy == v1.DeletionPolicyOrphan
+}
+
+// reconcileFinalizer adds or removes the orphan finalizer depending on the deletion policy,
+// returning true if the trigger was updated
+func (r *BatchTriggerReconciler) reconcileFinalizer(ctx context.Context, trigger *v1.BatchTrigger) (bool, error) {
+	var changed bool
+	if wantsOrphanFinalizer(trigger) {
+		changed = controllerutil.AddFinalizer(trigger, FinalizerOrphan)
+	} else {
+		changed = controllerutil.RemoveFinalizer(trigger, FinalizerOrphan)
+	}
+	if !changed {
+		return false, nil
+	}
+	return true, r.Update(ctx, trigger)
+}
+
+// finalize orphans the workloads of a trigger that is being deleted and then removes the finalizer
+func (r *BatchTriggerReconciler) finalize(ctx context.Context, trigger *v1.BatchTrigger) error {
+	if !controllerutil.ContainsFinalizer(trigger, FinalizerOrphan) {
+		return nil
+	}
+	if err := r.orphanWorkloads(ctx, trigger); err != nil {
+		return err
+	}
+	controllerutil.RemoveFinalizer(trigger, FinalizerOrphan)
+	return r.Update(ctx, trigger)
+}
+
+// ResourceDiscovery lists the namespaced kinds served by the cluster, as implemented by the discovery client
+type ResourceDiscovery interface {
+	ServerPreferredNamespacedResources() ([]*metav1.APIResourceList, error)
+}
+
+// orphanKinds returns the kinds that may have been created by a trigger, which is every namespaced kind that can
+// be listed and patched, or only Pods and Jobs without discovery
+func (r *BatchTriggerReconciler) orphanKinds() ([]schema.GroupVersionKind, error) {
+	if r.Discovery == nil {
+		return []schema.GroupVersionKind{
+			corev1.SchemeGroupVersion.WithKind("Pod"),
+			batchv1.SchemeGroupVersion.WithKind("Job"),
+		}, nil
+	}
+	lists, err := r.Discovery.ServerPreferredNamespacedResources()
+	// groups that failed discovery, e.g. unavailable aggregated APIs, are skipped
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, err
+	}
+	var kinds []schema.GroupVersionKind
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			verbs := sets.New(resource.Verbs...)
+			if strings.Contains(resource.Name, "/") || !verbs.HasAll("list", "patch") {
+				continue
+			}
+			kinds = append(kinds, gv.WithKind(resource.Kind))
+		}
+	}
+	return kinds, nil
+}
+
+// orphanWorkloads removes the owner references to the trigger from every object labelled with the trigger
+func (r *BatchTriggerReconciler) orphanWorkloads(ctx context.Context, trigger *v1.BatchTrigger) error {
+	kinds, err := r.orphanKinds()
+	if err != nil {
+		return err
+	}
+	var reader client.Reader = r.Client
+	if r.APIReader != nil {
+		reader = r.APIReader
+	}
+
+	for _, gvk := range kinds {
+		list := &metav1.PartialObjectMetadataList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := reader.List(ctx, list, client.InNamespace(trigger.Namespace), triggerLabels(trigger)); err != nil {
+			// kinds that the controller cannot list cannot have been created by it
+			if apierrors.IsForbidden(err) || apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err) {
+				continue
+			}
+			return err
+		}
+
+		for i := range list.Items {
+			obj := &list.Items[i]
+			obj.SetGroupVersionKind(gvk)
+			refs := obj.GetOwnerReferences()
+			var kept []metav1.OwnerReference
+			for _, ref := range refs {
+				if ref.UID != trigger.UID {
+					kept = append(kept, ref)
+				}
+			}
+			if len(kept) == len(refs) {
+				continue
+			}
+
+			patch := client.MergeFrom(obj.DeepCopy())
+			obj.SetOwnerReferences(kept)
+			if err := r.Patch(ctx, obj, patch); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+			log.FromContext(ctx).Info("Orphaned workload", "kind", gvk.Kind, "name", obj.GetName())
+		}
+	}
+	return nil
+}
+
+// podFinishedAt returns when the last container of a pod terminated
+func podFinishedAt(pod *corev1.Pod) time.Time {
+	var finished time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if t := status.State.Terminated; t != nil && t.FinishedAt.After(finished) {
+			finished = t.FinishedAt.Time
+		}
+	}
+	return finished
+}
+
+// cleanupFinishedPods deletes Pods created by the trigger that finished more than ttlAfterFinished ago
+func (r *BatchTriggerReconciler) cleanupFinishedPods(ctx context.Context, trigger *v1.BatchTrigger) error {
+	if trigger.Spec.TTLAfterFinished == nil {
+		return nil
+	}
+
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, triggerLabels(trigger)); err != nil {
+		return err
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			continue
+		}
+		// pods owned by a Job are cleaned up by the Job TTL
+		if metav1.GetControllerOf(pod) != nil && metav1.GetControllerOf(pod).Kind == "Job" {
+			continue
+		}
+		finished := podFinishedAt(pod)
+		if finished.IsZero() || time.Since(finished) < trigger.Spec.TTLAfterFinished.Duration {
+			continue
+		}
+		if err := r.Delete(ctx, pod); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		log.FromContext(ctx).Info("Deleted finished pod", "pod", pod.Namespace+"/"+pod.Name, "finished", finished)
+	}
+	return nil
+}
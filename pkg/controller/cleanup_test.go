@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flanksource/batch-runner/pkg"
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func finishedPod(name string, finished time.Time, owners ...metav1.OwnerReference) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			Labels:          map[string]string{pkg.LabelTrigger: "test", pkg.LabelTriggerNamespace: "default"},
+			OwnerReferences: owners,
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodSucceeded,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: metav1.NewTime(finished)}},
+			}},
+		},
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	RegisterTestingT(t)
+
+	trigger := &v1.BatchTrigger{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "trigger-uid"},
+		Spec: v1.Config{
+			OwnerReference:   true,
+			DeletionPolicy:   v1.DeletionPolicyOrphan,
+			TTLAfterFinished: &metav1.Duration{Duration: time.Hour},
+		},
+	}
+	owner := pkg.TriggerOwnerReference(&trigger.ObjectMeta)
+
+	t.Run("deletes pods finished before the ttl", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		c := fake.NewClientBuilder().WithScheme(GetScheme()).WithObjects(
+			finishedPod("old", time.Now().Add(-2*time.Hour)),
+			finishedPod("recent", time.Now().Add(-time.Minute)),
+		).Build()
+		r := &BatchTriggerReconciler{Client: c}
+
+		Expect(r.cleanupFinishedPods(context.Background(), trigger)).To(Succeed())
+
+		err := c.Get(context.Background(), client.ObjectKey{Name: "old", Namespace: "default"}, &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(c.Get(context.Background(), client.ObjectKey{Name: "recent", Namespace: "default"}, &corev1.Pod{})).To(Succeed())
+	})
+
+	t.Run("orphans workloads when the trigger is deleted", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		other := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "other", UID: "other-uid"}
+		c := fake.NewClientBuilder().WithScheme(GetScheme()).WithObjects(
+			trigger.DeepCopy(),
+			finishedPod("owned", time.Now(), owner, other),
+		).Build()
+		r := &BatchTriggerReconciler{Client: c}
+
+		var current v1.BatchTrigger
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(trigger), &current)).To(Succeed())
+		changed, err := r.reconcileFinalizer(context.Background(), &current)
+		Expect(err).To(BeNil())
+		Expect(changed).To(BeTrue())
+		Expect(current.Finalizers).To(ContainElement(FinalizerOrphan))
+
+		Expect(r.finalize(context.Background(), &current)).To(Succeed())
+		Expect(current.Finalizers).ToNot(ContainElement(FinalizerOrphan))
+
+		var pod corev1.Pod
+		Expect(c.Get(context.Background(), client.ObjectKey{Name: "owned", Namespace: "default"}, &pod)).To(Succeed())
+		Expect(pod.OwnerReferences).To(ConsistOf(other))
+	})
+
+	t.Run("orphans every kind labelled with the trigger", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:            "payload",
+			Namespace:       "default",
+			Labels:          map[string]string{pkg.LabelTrigger: "test", pkg.LabelTriggerNamespace: "default"},
+			OwnerReferences: []metav1.OwnerReference{owner},
+		}}
+		c := fake.NewClientBuilder().WithScheme(GetScheme()).WithObjects(
+			finishedPod("owned", time.Now(), owner),
+			secret,
+		).Build()
+		r := &BatchTriggerReconciler{Client: c, Discovery: staticDiscovery{{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "pods", Kind: "Pod", Namespaced: true, Verbs: []string{"list", "patch"}},
+				{Name: "pods/status", Kind: "Pod", Namespaced: true, Verbs: []string{"patch"}},
+				{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: []string{"list", "patch"}},
+				{Name: "bindings", Kind: "Binding", Namespaced: true, Verbs: []string{"create"}},
+			},
+		}}}
+
+		Expect(r.orphanWorkloads(context.Background(), trigger)).To(Succeed())
+
+		var pod corev1.Pod
+		Expect(c.Get(context.Background(), client.ObjectKey{Name: "owned", Namespace: "default"}, &pod)).To(Succeed())
+		Expect(pod.OwnerReferences).To(BeEmpty())
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(secret), secret)).To(Succeed())
+		Expect(secret.OwnerReferences).To(BeEmpty())
+	})
+}
+
+type staticDiscovery []*metav1.APIResourceList
+
+func (d staticDiscovery) ServerPreferredNamespacedResources() ([]*metav1.APIResourceList, error) {
+	return d, nil
+}
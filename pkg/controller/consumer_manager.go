@@ -2,13 +2,17 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
 	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
 	"github.com/flanksource/batch-runner/pkg"
 	dutyctx "github.com/flanksource/duty/context"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -18,6 +22,13 @@ const (
 	ConnectionStateStarting     = "Starting"
 )
 
+// Reasons of the connection events of a trigger
+const (
+	ReasonConnected       = "Connected"
+	ReasonDisconnected    = "Disconnected"
+	ReasonConnectionError = "ConnectionError"
+)
+
 type ConsumerStats struct {
 	mu                sync.Mutex
 	MessagesProcessed int64
@@ -25,6 +36,7 @@ type ConsumerStats struct {
 	MessagesRetried   int64
 	LastError         string
 	LastErrorTime     time.Time
+	LastArtifacts     []string
 	ConnectionState   string
 }
 
@@ -42,6 +54,12 @@ func (s *ConsumerStats) RecordFailed(err error) {
 	s.LastErrorTime = time.Now()
 }
 
+func (s *ConsumerStats) RecordArtifacts(urls []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LastArtifacts = urls
+}
+
 func (s *ConsumerStats) RecordRetried() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -54,6 +72,15 @@ func (s *ConsumerStats) SetConnectionState(state string) {
 	s.ConnectionState = state
 }
 
+// swapConnectionState sets the connection state and returns the previous state
+func (s *ConsumerStats) swapConnectionState(state string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.ConnectionState
+	s.ConnectionState = state
+	return previous
+}
+
 func (s *ConsumerStats) Snapshot() ConsumerStats {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -63,6 +90,7 @@ func (s *ConsumerStats) Snapshot() ConsumerStats {
 		MessagesRetried:   s.MessagesRetried,
 		LastError:         s.LastError,
 		LastErrorTime:     s.LastErrorTime,
+		LastArtifacts:     s.LastArtifacts,
 		ConnectionState:   s.ConnectionState,
 	}
 }
@@ -72,12 +100,16 @@ type ManagedConsumer struct {
 	config    *v1.Config
 	stats     *ConsumerStats
 	startedAt time.Time
+	// done is closed once the consumer has returned
+	done chan struct{}
 }
 
 type ConsumerManager struct {
 	mu        sync.RWMutex
 	consumers map[types.NamespacedName]*ManagedConsumer
 	rootCtx   dutyctx.Context
+	// Recorder records events on triggers and the workloads they create, events are not recorded if it is nil
+	Recorder record.EventRecorder
 }
 
 func NewConsumerManager(rootCtx dutyctx.Context) *ConsumerManager {
@@ -87,7 +119,12 @@ func NewConsumerManager(rootCtx dutyctx.Context) *ConsumerManager {
 	}
 }
 
-func (m *ConsumerManager) Start(key types.NamespacedName, config *v1.Config) error {
+// Start runs a consumer for the trigger, the trigger metadata is passed to the consumer via the context
+func (m *ConsumerManager) Start(trigger *v1.BatchTrigger) error {
+	key := types.NamespacedName{Name: trigger.Name, Namespace: trigger.Namespace}
+	config := trigger.Spec.DeepCopy()
+	meta := *trigger.ObjectMeta.DeepCopy()
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -105,30 +142,68 @@ func (m *ConsumerManager) Start(key types.NamespacedName, config *v1.Config) err
 		config:    config,
 		stats:     stats,
 		startedAt: time.Now(),
+		done:      make(chan struct{}),
 	}
 	m.consumers[key] = managed
 
+	ref := trigger.DeepCopy()
 	callbacks := &pkg.ConsumerCallbacks{
 		OnMessageProcessed: stats.RecordProcessed,
-		OnMessageFailed:    stats.RecordFailed,
-		OnMessageRetried:   stats.RecordRetried,
-		OnConnectionChange: stats.SetConnectionState,
+		OnMessageFailed: func(err error) {
+			// the reason tells timeouts apart from other failures in the status
+			stats.RecordFailed(errors.New(pkg.FailureMessage(err)))
+		},
+		OnMessageRetried: stats.RecordRetried,
+		OnConnectionChange: func(state string) {
+			m.setConnectionState(ref, stats, state)
+		},
+		OnArtifactsUploaded: stats.RecordArtifacts,
+		OnEvent: func(event pkg.Event) {
+			m.recordEvent(ref, event)
+		},
 	}
 
 	go func() {
-		stats.SetConnectionState(ConnectionStateConnected)
-		err := pkg.RunConsumerWithCallbacks(m.rootCtx.Wrap(ctx), config, callbacks)
+		defer close(managed.done)
+		m.setConnectionState(ref, stats, ConnectionStateConnected)
+		err := pkg.RunConsumerWithCallbacks(m.rootCtx.Wrap(ctx).WithObject(meta), config, callbacks)
 		if err != nil && ctx.Err() == nil {
-			stats.SetConnectionState(ConnectionStateError)
+			m.setConnectionState(ref, stats, ConnectionStateError)
 			stats.RecordFailed(err)
+			m.recordEvent(ref, pkg.Event{Type: corev1.EventTypeWarning, Reason: ReasonConnectionError, Message: err.Error()})
 		} else {
-			stats.SetConnectionState(ConnectionStateDisconnected)
+			m.setConnectionState(ref, stats, ConnectionStateDisconnected)
 		}
 	}()
 
 	return nil
 }
 
+// setConnectionState records an event when the connection state of a consumer changes, errors are recorded
+// with the error once the consumer returns
+func (m *ConsumerManager) setConnectionState(trigger *v1.BatchTrigger, stats *ConsumerStats, state string) {
+	if stats.swapConnectionState(state) == state {
+		return
+	}
+	switch state {
+	case ConnectionStateConnected:
+		m.recordEvent(trigger, pkg.Event{Type: corev1.EventTypeNormal, Reason: ReasonConnected, Message: "Consuming from " + trigger.Spec.String()})
+	case ConnectionStateDisconnected:
+		m.recordEvent(trigger, pkg.Event{Type: corev1.EventTypeNormal, Reason: ReasonDisconnected, Message: "Stopped consuming from " + trigger.Spec.String()})
+	}
+}
+
+// recordEvent records event on the trigger, and on the workload that was created if there is one
+func (m *ConsumerManager) recordEvent(trigger *v1.BatchTrigger, event pkg.Event) {
+	if m.Recorder == nil {
+		return
+	}
+	m.Recorder.Event(trigger, event.Type, event.Reason, event.Message)
+	if obj, ok := event.Object.(runtime.Object); ok {
+		m.Recorder.Eventf(obj, event.Type, event.Reason, "%s by BatchTrigger %s/%s", event.Message, trigger.Namespace, trigger.Name)
+	}
+}
+
 func (m *ConsumerManager) Stop(key types.NamespacedName) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -136,6 +211,16 @@ func (m *ConsumerManager) Stop(key types.NamespacedName) {
 	if managed, exists := m.consumers[key]; exists {
 		managed.cancel()
 		delete(m.consumers, key)
+		go m.deleteMetrics(key, managed)
+	}
+}
+
+// deleteMetrics deletes the metrics of a trigger once its consumer has returned, unless it was restarted with a
+// new config in the meantime
+func (m *ConsumerManager) deleteMetrics(key types.NamespacedName, managed *ManagedConsumer) {
+	<-managed.done
+	if !m.IsRunning(key) {
+		pkg.DeleteTriggerMetrics(key.String())
 	}
 }
 
@@ -157,18 +242,20 @@ func (m *ConsumerManager) GetStats(key types.NamespacedName) *ConsumerStats {
 	return &ConsumerStats{ConnectionState: ConnectionStateDisconnected}
 }
 
-func (m *ConsumerManager) UpdateConfig(key types.NamespacedName, newConfig *v1.Config) error {
+func (m *ConsumerManager) UpdateConfig(trigger *v1.BatchTrigger) error {
+	key := types.NamespacedName{Name: trigger.Name, Namespace: trigger.Namespace}
+
 	m.mu.Lock()
 	managed, exists := m.consumers[key]
 	m.mu.Unlock()
 
 	if !exists {
-		return m.Start(key, newConfig)
+		return m.Start(trigger)
 	}
 
-	if configChanged(managed.config, newConfig) {
+	if configChanged(managed.config, &trigger.Spec) {
 		m.Stop(key)
-		return m.Start(key, newConfig)
+		return m.Start(trigger)
 	}
 
 	return nil
@@ -185,5 +272,6 @@ func (m *ConsumerManager) StopAll() {
 	for key, managed := range m.consumers {
 		managed.cancel()
 		delete(m.consumers, key)
+		go m.deleteMetrics(key, managed)
 	}
 }
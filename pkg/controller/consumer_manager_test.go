@@ -4,12 +4,16 @@ import (
 	"context"
 	"testing"
 
+	"github.com/flanksource/batch-runner/pkg"
 	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
 	dutyctx "github.com/flanksource/duty/context"
+	dutyps "github.com/flanksource/duty/pubsub"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 func TestConsumerStats(t *testing.T) {
@@ -87,6 +91,27 @@ func TestConsumerManagerUnit(t *testing.T) {
 		mgr.StopAll()
 	})
 
+	t.Run("Stop deletes the metrics of the trigger", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		rootCtx := dutyctx.NewContext(context.Background())
+		mgr := NewConsumerManager(rootCtx)
+
+		trigger := &v1.BatchTrigger{
+			ObjectMeta: metav1.ObjectMeta{Name: "metrics", Namespace: "default"},
+			Spec: v1.Config{
+				Exec:        &v1.ExecAction{Script: "exit 0"},
+				QueueConfig: dutyps.QueueConfig{Memory: &dutyps.MemoryConfig{QueueName: "stop-metrics"}},
+			},
+		}
+		key := types.NamespacedName{Name: trigger.Name, Namespace: trigger.Namespace}
+		Expect(mgr.Start(trigger)).To(Succeed())
+		Eventually(func() int { return triggerSeries("default/metrics") }, "5s").Should(BeNumerically(">", 0))
+
+		mgr.Stop(key)
+		Eventually(func() int { return triggerSeries("default/metrics") }, "5s").Should(BeZero())
+	})
+
 	t.Run("configChanged detects differences", func(t *testing.T) {
 		RegisterTestingT(t)
 
@@ -125,4 +150,46 @@ func TestConsumerManagerUnit(t *testing.T) {
 		Expect(configChanged(config1, config2)).To(BeTrue())
 		Expect(configChanged(config1, config1)).To(BeFalse())
 	})
+
+	t.Run("records events on the trigger and created workloads", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		recorder := record.NewFakeRecorder(10)
+		mgr := NewConsumerManager(dutyctx.NewContext(context.Background()))
+		mgr.Recorder = recorder
+
+		trigger := &v1.BatchTrigger{ObjectMeta: metav1.ObjectMeta{Name: "sync", Namespace: "jobs"}}
+		stats := &ConsumerStats{ConnectionState: ConnectionStateStarting}
+
+		mgr.setConnectionState(trigger, stats, ConnectionStateConnected)
+		mgr.setConnectionState(trigger, stats, ConnectionStateConnected)
+		Expect(recorder.Events).To(HaveLen(1))
+		Expect(<-recorder.Events).To(HavePrefix("Normal Connected "))
+
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "sync-1", Namespace: "jobs"}}
+		mgr.recordEvent(trigger, pkg.Event{Type: corev1.EventTypeNormal, Reason: pkg.ReasonCreated, Message: "Created jobs/sync-1", Object: pod})
+		Expect(<-recorder.Events).To(Equal("Normal Created Created jobs/sync-1"))
+		Expect(<-recorder.Events).To(Equal("Normal Created Created jobs/sync-1 by BatchTrigger jobs/sync"))
+
+		mgr.setConnectionState(trigger, stats, ConnectionStateDisconnected)
+		Expect(<-recorder.Events).To(HavePrefix("Normal Disconnected "))
+		Expect(recorder.Events).To(BeEmpty())
+	})
+}
+
+// triggerSeries counts the metric series of a trigger
+func triggerSeries(trigger string) int {
+	families, err := ctrlmetrics.Registry.Gather()
+	Expect(err).To(BeNil())
+	count := 0
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "trigger" && label.GetValue() == trigger {
+					count++
+				}
+			}
+		}
+	}
+	return count
 }
@@ -14,12 +14,14 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-// +kubebuilder:rbac:groups=batch.flanksource.com,resources=batchtriggers,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch.flanksource.com,resources=batchtriggers,verbs=get;list;watch;update
 // +kubebuilder:rbac:groups=batch.flanksource.com,resources=batchtriggers/status,verbs=get;update;patch
-// +kubebuilder:rbac:groups="",resources=pods,verbs=create;get;list;watch
-// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=create;get;list;watch
-// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch.flanksource.com,resources=batchtriggers/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=pods,verbs=create;get;list;watch;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=create;get;list;watch;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;patch;delete
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="";events.k8s.io,resources=events,verbs=create;patch
 
 const (
 	ConditionTypeReady       = "Ready"
@@ -31,6 +33,10 @@ type BatchTriggerReconciler struct {
 	client.Client
 	Scheme  *runtime.Scheme
 	Manager *ConsumerManager
+	// Discovery finds the kinds of the workloads to orphan, only Pods and Jobs are orphaned if it is nil
+	Discovery ResourceDiscovery
+	// APIReader lists workloads to orphan without starting informers for every kind, the client is used if nil
+	APIReader client.Reader
 }
 
 func (r *BatchTriggerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -49,17 +55,25 @@ func (r *BatchTriggerReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	if !trigger.DeletionTimestamp.IsZero() {
 		logger.Info("BatchTrigger being deleted, stopping consumer")
 		r.Manager.Stop(req.NamespacedName)
+		if err := r.finalize(ctx, &trigger); err != nil {
+			logger.Error(err, "Failed to orphan workloads")
+			return ctrl.Result{}, err
+		}
 		return ctrl.Result{}, nil
 	}
 
+	if _, err := r.reconcileFinalizer(ctx, &trigger); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	if r.Manager.IsRunning(req.NamespacedName) {
-		if err := r.Manager.UpdateConfig(req.NamespacedName, &trigger.Spec); err != nil {
+		if err := r.Manager.UpdateConfig(&trigger); err != nil {
 			logger.Error(err, "Failed to update consumer config")
 			return ctrl.Result{RequeueAfter: 30 * time.Second}, err
 		}
 	} else {
 		logger.Info("Starting consumer", "queue", trigger.Spec.String())
-		if err := r.Manager.Start(req.NamespacedName, &trigger.Spec); err != nil {
+		if err := r.Manager.Start(&trigger); err != nil {
 			logger.Error(err, "Failed to start consumer")
 			r.setCondition(&trigger, ConditionTypeDegraded, metav1.ConditionTrue, "StartFailed", err.Error())
 			if err := r.Status().Update(ctx, &trigger); err != nil {
@@ -69,6 +83,10 @@ func (r *BatchTriggerReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		}
 	}
 
+	if err := r.cleanupFinishedPods(ctx, &trigger); err != nil {
+		logger.Error(err, "Failed to clean up finished pods")
+	}
+
 	stats := r.Manager.GetStats(req.NamespacedName)
 	r.updateStatus(&trigger, stats)
 
@@ -91,6 +109,9 @@ func (r *BatchTriggerReconciler) updateStatus(trigger *v1.BatchTrigger, stats *C
 		t := metav1.NewTime(stats.LastErrorTime)
 		trigger.Status.LastErrorTime = &t
 	}
+	if len(stats.LastArtifacts) > 0 {
+		trigger.Status.LastArtifacts = stats.LastArtifacts
+	}
 
 	switch stats.ConnectionState {
 	case ConnectionStateConnected:
@@ -5,6 +5,7 @@ import (
 	dutyctx "github.com/flanksource/duty/context"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
@@ -22,11 +23,19 @@ func GetScheme() *runtime.Scheme {
 
 func SetupWithManager(mgr ctrl.Manager, rootCtx dutyctx.Context) error {
 	consumerMgr := NewConsumerManager(rootCtx)
+	consumerMgr.Recorder = mgr.GetEventRecorderFor("batch-runner")
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
 
 	reconciler := &BatchTriggerReconciler{
-		Client:  mgr.GetClient(),
-		Scheme:  mgr.GetScheme(),
-		Manager: consumerMgr,
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Manager:   consumerMgr,
+		Discovery: discoveryClient,
+		APIReader: mgr.GetAPIReader(),
 	}
 
 	return reconciler.SetupWithManager(mgr)
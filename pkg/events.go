@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reasons of the events of a consumer
+const (
+	ReasonCreated          = "Created"
+	ReasonCompleted        = "Completed"
+	ReasonSkipped          = "Skipped"
+	ReasonTemplateFailed   = "TemplateFailed"
+	ReasonCreateFailed     = "CreateFailed"
+	ReasonActionFailed     = "ActionFailed"
+	ReasonRetriesExhausted = "RetriesExhausted"
+)
+
+// Event is something that happened while handling a message, which the controller records as a Kubernetes
+// Event on the BatchTrigger
+type Event struct {
+	// Type is Normal or Warning
+	Type    string
+	Reason  string
+	Message string
+	// Object is the workload that was created, which the event is also recorded on
+	Object metav1.Object
+}
+
+func emitEvent(callbacks *ConsumerCallbacks, event Event) {
+	if callbacks != nil && callbacks.OnEvent != nil {
+		callbacks.OnEvent(event)
+	}
+}
+
+// failureEventReason is Timeout for actions that timed out, so that they can be told apart from other failures,
+// or else reason
+func failureEventReason(err error, reason string) string {
+	if FailureReason(err) == ReasonTimeout {
+		return ReasonTimeout
+	}
+	return reason
+}
+
+func warningEvent(callbacks *ConsumerCallbacks, reason, format string, args ...any) {
+	emitEvent(callbacks, Event{Type: corev1.EventTypeWarning, Reason: reason, Message: fmt.Sprintf(format, args...)})
+}
+
+// completedEvent records a script that exited successfully, with the URLs of the artifacts it uploaded
+func completedEvent(id string, result ActionResult) Event {
+	message := fmt.Sprintf("Message %s completed: %s", id, result.Created)
+	if len(result.Artifacts) > 0 {
+		message += ", artifacts: " + strings.Join(result.Artifacts, ", ")
+	}
+	return Event{Type: corev1.EventTypeNormal, Reason: ReasonCompleted, Message: message}
+}
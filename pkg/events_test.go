@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	"github.com/flanksource/duty/shell"
+	. "github.com/onsi/gomega"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/mempubsub"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEvents(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	topic := mempubsub.NewTopic()
+	t.Cleanup(func() { _ = topic.Shutdown(ctx) })
+	sub := mempubsub.NewSubscription(topic, time.Minute)
+	t.Cleanup(func() { _ = sub.Shutdown(ctx) })
+
+	receive := func(id string) *pubsub.Message {
+		Expect(topic.Send(ctx, &pubsub.Message{Body: []byte("{}")})).To(Succeed())
+		msg, err := sub.Receive(ctx)
+		Expect(err).To(BeNil())
+		msg.LoggableID = id
+		return msg
+	}
+
+	var events []Event
+	callbacks := &ConsumerCallbacks{OnEvent: func(e Event) { events = append(events, e) }}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "sync-1", Namespace: "jobs"}}
+
+	t.Run("records created workloads", func(t *testing.T) {
+		RegisterTestingT(t)
+		events = nil
+
+		shouldRetryWithCallbacks(ctx, receive("created"), pod, nil, callbacks)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Type).To(Equal(corev1.EventTypeNormal))
+		Expect(events[0].Reason).To(Equal(ReasonCreated))
+		Expect(events[0].Message).To(Equal("Created jobs/sync-1 for message created"))
+		Expect(events[0].Object).To(Equal(pod))
+	})
+
+	t.Run("records skipped workloads", func(t *testing.T) {
+		RegisterTestingT(t)
+		events = nil
+
+		skipped(ctx, receive("skipped"), pod, callbacks)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Type).To(Equal(corev1.EventTypeNormal))
+		Expect(events[0].Reason).To(Equal(ReasonSkipped))
+		Expect(events[0].Message).To(Equal("Kept existing jobs/sync-1 for message skipped"))
+		Expect(events[0].Object).To(Equal(pod))
+	})
+
+	t.Run("records unretryable create errors", func(t *testing.T) {
+		RegisterTestingT(t)
+		events = nil
+
+		shouldRetryWithCallbacks(ctx, receive("forbidden"), pod, kerrors.NewForbidden(corev1.Resource("pods"), "sync-1", errors.New("denied")), callbacks)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Type).To(Equal(corev1.EventTypeWarning))
+		Expect(events[0].Reason).To(Equal(ReasonCreateFailed))
+		Expect(events[0].Object).To(BeNil())
+	})
+
+	t.Run("records exhausted retries", func(t *testing.T) {
+		RegisterTestingT(t)
+		events = nil
+
+		r := &v1.Retry{Attempts: 1}
+		err := errors.New("exit status 1")
+		retryOrFail(ctx, receive("retried"), r, err, callbacks)
+		Expect(events).To(BeEmpty())
+
+		retryOrFail(ctx, receive("retried"), r, err, callbacks)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Reason).To(Equal(ReasonRetriesExhausted))
+		Expect(events[0].Message).To(ContainSubstring("Error: exit status 1"))
+	})
+
+	t.Run("records timeouts with their own reason", func(t *testing.T) {
+		RegisterTestingT(t)
+		events = nil
+
+		r := &v1.Retry{Attempts: 1}
+		err := TimeoutError{Timeout: time.Minute}
+		retryOrFail(ctx, receive("timed-out"), r, err, callbacks)
+		Expect(events).To(BeEmpty())
+
+		retryOrFail(ctx, receive("timed-out"), r, err, callbacks)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Type).To(Equal(corev1.EventTypeWarning))
+		Expect(events[0].Reason).To(Equal(ReasonTimeout))
+		Expect(events[0].Message).To(ContainSubstring("Timeout: exceeded 1m0s"))
+	})
+
+	t.Run("records completed scripts with their artifacts", func(t *testing.T) {
+		RegisterTestingT(t)
+		events = nil
+
+		dir := t.TempDir()
+		config := &v1.Config{
+			Exec: &v1.ExecAction{
+				Script:    "#!/bin/sh\necho ok",
+				Artifacts: []shell.Artifact{{Path: "/dev/stdout"}},
+			},
+			ArtifactStore: &v1.ArtifactStore{URL: "file://" + dir, Prefix: "reports"},
+		}
+		_, err := handleMessage(ctx, nil, config, receive("report-1"), time.Now(), nil, callbacks, newConsumerMetrics("test"))
+		Expect(err).To(BeNil())
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Type).To(Equal(corev1.EventTypeNormal))
+		Expect(events[0].Reason).To(Equal(ReasonCompleted))
+		Expect(events[0].Message).To(HavePrefix("Message report-1 completed: script exited with 0, artifacts: "))
+		Expect(events[0].Message).To(HaveSuffix("reports/stdout"))
+	})
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	"github.com/flanksource/duty/shell"
+)
+
+const (
+	ReasonError   = "Error"
+	ReasonTimeout = "Timeout"
+
+	defaultKillGracePeriod = 10 * time.Second
+)
+
+// TimeoutError is returned when an action exceeds its timeout
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("exceeded %s", e.Timeout)
+}
+
+// FailureReason returns a short reason for err, e.g. Timeout
+func FailureReason(err error) string {
+	if errors.As(err, &TimeoutError{}) {
+		return ReasonTimeout
+	}
+	return ReasonError
+}
+
+// FailureMessage prefixes err with its reason, e.g. "Timeout: exceeded 5m0s"
+func FailureMessage(err error) string {
+	return FailureReason(err) + ": " + err.Error()
+}
+
+func killGracePeriod(exec v1.ExecAction) time.Duration {
+	if exec.KillGracePeriod != nil {
+		return exec.KillGracePeriod.Duration
+	}
+	return defaultKillGracePeriod
+}
+
+// runExec runs the script of an already templated action in its own process group, which is killed
+// when the timeout expires or ctx is cancelled
+func runExec(ctx context.Context, exec v1.ExecAction) (*shell.ExecDetails, error) {
+	runCtx := ctx
+	if exec.Timeout != nil && exec.Timeout.Duration > 0 {
+		var cancel func()
+		runCtx, cancel = ctx.WithTimeout(exec.Timeout.Duration)
+		defer cancel()
+	}
+
+	cmd, err := shell.CreateCommandFromScript(runCtx, exec.Script)
+	if err != nil {
+		return nil, err
+	}
+	if err := configureCmd(cmd, exec); err != nil {
+		return nil, err
+	}
+
+	details, err := shell.RunCmd(runCtx, exec.ToShellExec(), cmd)
+	if details != nil {
+		// artifacts are stored relative to the working directory of the script
+		for i, a := range details.Artifacts {
+			if rel, err := filepath.Rel(cmd.Dir, a.Path); err == nil && filepath.IsLocal(rel) {
+				details.Artifacts[i].Path = filepath.ToSlash(rel)
+			}
+		}
+	}
+	if runCtx.Err() != nil {
+		killProcessGroup(cmd)
+		if ctx.Err() == nil {
+			return details, TimeoutError{Timeout: exec.Timeout.Duration}
+		}
+	}
+	return details, err
+}
@@ -0,0 +1,69 @@
+//go:build !windows
+
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRunExec(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+
+	t.Run("kills the process group on timeout", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		marker := filepath.Join(t.TempDir(), "marker")
+		start := time.Now()
+		_, err := runExec(ctx, v1.ExecAction{
+			Script:          "#!/bin/sh\n(sleep 2 && touch " + marker + ") &\nsleep 30",
+			Timeout:         &metav1.Duration{Duration: 500 * time.Millisecond},
+			KillGracePeriod: &metav1.Duration{Duration: 500 * time.Millisecond},
+		})
+		Expect(err).To(MatchError(TimeoutError{Timeout: 500 * time.Millisecond}))
+		Expect(FailureReason(err)).To(Equal(ReasonTimeout))
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+		// the background child must not survive the timeout
+		time.Sleep(2 * time.Second)
+		Expect(marker).ToNot(BeAnExistingFile())
+	})
+
+	t.Run("applies limits", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		memory := resource.MustParse("512Mi")
+		details, err := runExec(ctx, v1.ExecAction{
+			Script: "#!/bin/sh\nulimit -n && ulimit -v",
+			Limits: &v1.ExecLimits{OpenFiles: 64, Memory: &memory},
+		})
+		Expect(err).To(BeNil())
+		Expect(details.Stdout).To(Equal("64\n524288"))
+	})
+
+	t.Run("returns script errors", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		details, err := runExec(ctx, v1.ExecAction{Script: "#!/bin/sh\nexit 3", Timeout: &metav1.Duration{Duration: time.Minute}})
+		Expect(err).ToNot(BeNil())
+		Expect(FailureReason(err)).To(Equal(ReasonError))
+		Expect(details.ExitCode).To(Equal(3))
+	})
+
+	t.Run("requires runAsGroup for unknown users", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		uid := int64(424242)
+		_, err := runExec(ctx, v1.ExecAction{Script: "#!/bin/sh\nid", RunAsUser: &uid})
+		Expect(err).To(MatchError(ContainSubstring("set runAsGroup")))
+	})
+}
@@ -0,0 +1,82 @@
+//go:build !windows
+
+package pkg
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+)
+
+// ulimitArgs returns the ulimit commands for limits, memory is in KiB
+func ulimitArgs(limits *v1.ExecLimits) []string {
+	if limits == nil {
+		return nil
+	}
+	var args []string
+	if limits.CPUSeconds > 0 {
+		args = append(args, fmt.Sprintf("ulimit -t %d", limits.CPUSeconds))
+	}
+	if limits.Memory != nil && !limits.Memory.IsZero() {
+		args = append(args, fmt.Sprintf("ulimit -v %d", limits.Memory.Value()/1024))
+	}
+	if limits.OpenFiles > 0 {
+		args = append(args, fmt.Sprintf("ulimit -n %d", limits.OpenFiles))
+	}
+	return args
+}
+
+// primaryGroup returns the primary group of a user, users without a passwd entry must set runAsGroup
+func primaryGroup(uid int64) (int64, error) {
+	u, err := user.LookupId(strconv.FormatInt(uid, 10))
+	if err != nil {
+		return 0, fmt.Errorf("error looking up the primary group of uid %d, set runAsGroup: %w", uid, err)
+	}
+	return strconv.ParseInt(u.Gid, 10, 64)
+}
+
+// configureCmd starts cmd in a new process group that is sent SIGTERM on cancellation, and applies
+// the limits and credentials of the action
+func configureCmd(cmd *exec.Cmd, action v1.ExecAction) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	// after the grace period the process is killed and its output closed, see killProcessGroup for children
+	cmd.WaitDelay = killGracePeriod(action)
+
+	if action.RunAsUser != nil {
+		gid := action.RunAsGroup
+		if gid == nil {
+			primary, err := primaryGroup(*action.RunAsUser)
+			if err != nil {
+				return err
+			}
+			gid = &primary
+		}
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(*action.RunAsUser), Gid: uint32(*gid)}
+	}
+
+	if limits := ulimitArgs(action.Limits); len(limits) > 0 {
+		sh, err := exec.LookPath("sh")
+		if err != nil {
+			return err
+		}
+		script := strings.Join(limits, " && ") + ` && exec "$@"`
+		cmd.Args = append([]string{sh, "-c", script, "sh", cmd.Path}, cmd.Args[1:]...)
+		cmd.Path = sh
+	}
+	return nil
+}
+
+// killProcessGroup kills any processes in the group of cmd that survived the grace period
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
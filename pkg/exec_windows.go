@@ -0,0 +1,26 @@
+//go:build windows
+
+package pkg
+
+import (
+	"fmt"
+	"os/exec"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+)
+
+// configureCmd kills cmd after the grace period on cancellation, process groups, limits
+// and credentials are not supported on windows
+func configureCmd(cmd *exec.Cmd, action v1.ExecAction) error {
+	if action.Limits != nil || action.RunAsUser != nil || action.RunAsGroup != nil {
+		return fmt.Errorf("exec limits and runAs are not supported on windows")
+	}
+	cmd.WaitDelay = killGracePeriod(action)
+	return nil
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+}
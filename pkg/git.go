@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/connection"
+	"github.com/flanksource/duty/context"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/samber/lo"
+	"github.com/samber/oops"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// marshalManifests returns objects as a multi-document YAML file
+func marshalManifests(objects []unstructured.Unstructured) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, obj := range objects {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		b, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+// commitToGit writes content to the path of an already templated action, and commits and pushes it,
+// returning the hash of the new commit, or an empty hash if the file was already up to date
+func commitToGit(ctx context.Context, action v1.GitAction, content []byte) (string, error) {
+	if action.Path == "" || !filepath.IsLocal(action.Path) {
+		return "", fmt.Errorf("git path must be a relative path within the repository: %q", action.Path)
+	}
+
+	conn := action.GitConnection
+	if err := conn.HydrateConnection(ctx); err != nil {
+		return "", oops.Wrapf(err, "error hydrating git connection")
+	}
+	client, err := connection.CreateGitConfig(ctx, &conn)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := os.MkdirTemp("", "batch-runner-git-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := client.Clone(ctx, dir); err != nil {
+		return "", err
+	}
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+	tree, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+
+	branch := lo.CoalesceOrEmpty(action.PushBranch, client.Branch)
+	if branch != client.Branch {
+		if err := checkoutPushBranch(ctx, repo, tree, client, branch); err != nil {
+			return "", err
+		}
+	}
+
+	file := filepath.Join(dir, action.Path)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(file, content, 0o644); err != nil {
+		return "", err
+	}
+	if _, err := tree.Add(filepath.ToSlash(action.Path)); err != nil {
+		return "", oops.Wrapf(err, "error adding %s", action.Path)
+	}
+
+	status, err := tree.Status()
+	if err != nil {
+		return "", err
+	}
+	if status.IsClean() {
+		return "", nil
+	}
+
+	hash, err := tree.Commit(lo.CoalesceOrEmpty(action.CommitMessage, "Update "+action.Path), &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  lo.CoalesceOrEmpty(action.AuthorName, "batch-runner"),
+			Email: lo.CoalesceOrEmpty(action.AuthorEmail, "batch-runner@flanksource.com"),
+			When:  time.Now(),
+		},
+	})
+	if err != nil {
+		return "", oops.Wrapf(err, "error committing %s", action.Path)
+	}
+
+	refSpec := config.RefSpec(fmt.Sprintf("refs/heads/%s:refs/heads/%s", branch, branch))
+	if err := repo.PushContext(ctx, &git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{refSpec},
+		Auth:       client.Auth,
+	}); err != nil {
+		return "", oops.Wrapf(err, "error pushing to %s", branch)
+	}
+	return hash.String(), nil
+}
+
+// checkoutPushBranch checks out branch from the remote if it exists, or creates it from HEAD
+func checkoutPushBranch(ctx context.Context, repo *git.Repository, tree *git.Worktree, client *connection.GitClient, branch string) error {
+	remote := plumbing.NewRemoteReferenceName("origin", branch)
+	err := repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+refs/heads/%s:%s", branch, remote))},
+		Auth:       client.Auth,
+		Depth:      client.Depth,
+	})
+
+	switch {
+	case err == nil || errors.Is(err, git.NoErrAlreadyUpToDate):
+		ref, err := repo.Reference(remote, true)
+		if err != nil {
+			return err
+		}
+		return tree.Checkout(&git.CheckoutOptions{
+			Branch: plumbing.NewBranchReferenceName(branch),
+			Hash:   ref.Hash(),
+			Create: true,
+		})
+	case errors.Is(err, git.NoMatchingRefSpecError{}):
+		return tree.Checkout(&git.CheckoutOptions{
+			Branch: plumbing.NewBranchReferenceName(branch),
+			Create: true,
+		})
+	default:
+		return oops.Wrapf(err, "error fetching %s", branch)
+	}
+}
@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/connection"
+	"github.com/flanksource/duty/context"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	. "github.com/onsi/gomega"
+)
+
+// bareRepo returns the URL of a local bare repository with an initial commit on main
+func bareRepo(t *testing.T) string {
+	bare := filepath.Join(t.TempDir(), "repo.git")
+	_, err := git.PlainInit(bare, true)
+	Expect(err).To(BeNil())
+
+	dir := t.TempDir()
+	repo, err := git.PlainInitWithOptions(dir, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: plumbing.Main},
+	})
+	Expect(err).To(BeNil())
+	Expect(os.WriteFile(filepath.Join(dir, "README.md"), []byte("test"), 0o644)).To(Succeed())
+	tree, err := repo.Worktree()
+	Expect(err).To(BeNil())
+	_, err = tree.Add("README.md")
+	Expect(err).To(BeNil())
+	_, err = tree.Commit("initial", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+	Expect(err).To(BeNil())
+	_, err = repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{bare}})
+	Expect(err).To(BeNil())
+	Expect(repo.Push(&git.PushOptions{RemoteName: "origin"})).To(Succeed())
+
+	return "file://" + bare
+}
+
+// readFile returns the content of path on branch of the bare repository at url
+func readFile(url, branch, path string) string {
+	repo, err := git.PlainOpen(url[len("file://"):])
+	Expect(err).To(BeNil())
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	Expect(err).To(BeNil())
+	commit, err := repo.CommitObject(ref.Hash())
+	Expect(err).To(BeNil())
+	file, err := commit.File(path)
+	Expect(err).To(BeNil())
+	content, err := file.Contents()
+	Expect(err).To(BeNil())
+	return content
+}
+
+func TestCommitToGit(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	url := bareRepo(t)
+	action := v1.GitAction{
+		GitConnection: connection.GitConnection{URL: url},
+		Path:          "envs/first/manifests.yaml",
+	}
+
+	t.Run("pushes to the cloned branch", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		hash, err := commitToGit(ctx, action, []byte("kind: ConfigMap\n"))
+		Expect(err).To(BeNil())
+		Expect(hash).ToNot(BeEmpty())
+		Expect(readFile(url, "main", action.Path)).To(Equal("kind: ConfigMap\n"))
+	})
+
+	t.Run("does not commit unchanged files", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		hash, err := commitToGit(ctx, action, []byte("kind: ConfigMap\n"))
+		Expect(err).To(BeNil())
+		Expect(hash).To(BeEmpty())
+	})
+
+	t.Run("pushes to a new and then an existing branch", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		branch := action
+		branch.PushBranch = "env-first"
+		_, err := commitToGit(ctx, branch, []byte("kind: Secret\n"))
+		Expect(err).To(BeNil())
+		Expect(readFile(url, "env-first", action.Path)).To(Equal("kind: Secret\n"))
+
+		_, err = commitToGit(ctx, branch, []byte("kind: Job\n"))
+		Expect(err).To(BeNil())
+		Expect(readFile(url, "env-first", action.Path)).To(Equal("kind: Job\n"))
+		Expect(readFile(url, "main", action.Path)).To(Equal("kind: ConfigMap\n"))
+	})
+
+	t.Run("rejects paths outside the repository", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		outside := action
+		outside.Path = "../manifests.yaml"
+		_, err := commitToGit(ctx, outside, []byte("kind: Job\n"))
+		Expect(err).To(MatchError(ContainSubstring("relative path")))
+	})
+}
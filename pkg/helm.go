@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	dutyKubernetes "github.com/flanksource/duty/kubernetes"
+	"github.com/samber/lo"
+	"github.com/samber/oops"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const defaultHelmTimeout = 5 * time.Minute
+
+func helmTimeout(action v1.HelmAction) time.Duration {
+	if action.Timeout != nil {
+		return action.Timeout.Duration
+	}
+	return defaultHelmTimeout
+}
+
+// restClientGetter lets helm use the rest config of the Kubernetes client instead of loading a kubeconfig
+type restClientGetter struct {
+	config    *rest.Config
+	namespace string
+}
+
+func (g restClientGetter) ToRESTConfig() (*rest.Config, error) {
+	return rest.CopyConfig(g.config), nil
+}
+
+func (g restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	client, err := discovery.NewDiscoveryClientForConfig(g.config)
+	if err != nil {
+		return nil, err
+	}
+	return memory.NewMemCacheClient(client), nil
+}
+
+func (g restClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	client, err := g.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	return restmapper.NewDeferredDiscoveryRESTMapper(client), nil
+}
+
+func (g restClientGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	return clientcmd.NewDefaultClientConfig(*clientcmdapi.NewConfig(), &clientcmd.ConfigOverrides{
+		Context: clientcmdapi.Context{Namespace: g.namespace},
+	})
+}
+
+// newHelmConfiguration returns the helm configuration of a namespace, which stores releases as Secrets using
+// the same credentials as the Kubernetes client
+func newHelmConfiguration(ctx context.Context, client *dutyKubernetes.Client, namespace string) (*action.Configuration, error) {
+	cfg := new(action.Configuration)
+	getter := restClientGetter{config: client.RestConfig(), namespace: namespace}
+	if err := cfg.Init(getter, namespace, "secret", ctx.Debugf); err != nil {
+		return nil, oops.Wrapf(err, "error initializing helm")
+	}
+	registryClient, err := registry.NewClient(registry.ClientOptCredentialsFile(cli.New().RegistryConfig))
+	if err != nil {
+		return nil, oops.Wrapf(err, "error creating registry client")
+	}
+	cfg.RegistryClient = registryClient
+	return cfg, nil
+}
+
+// helmNamespace returns the namespace of the release of an action
+func helmNamespace(ctx context.Context, action v1.HelmAction) string {
+	return lo.CoalesceOrEmpty(action.Namespace, ctx.GetNamespace(), "default")
+}
+
+// runHelmAction installs, upgrades or uninstalls the release of an already templated action,
+// returning nil if the release was uninstalled
+func runHelmAction(ctx context.Context, cfg *action.Configuration, helm v1.HelmAction) (*release.Release, error) {
+	if helm.Release == "" || helm.Chart == "" {
+		return nil, fmt.Errorf("helm action requires a chart and release")
+	}
+	helm.Namespace = helmNamespace(ctx, helm)
+
+	if strings.TrimSpace(helm.Uninstall) == "true" {
+		uninstall := action.NewUninstall(cfg)
+		uninstall.Wait = helm.Wait
+		uninstall.Timeout = helmTimeout(helm)
+		uninstall.IgnoreNotFound = true
+		_, err := uninstall.Run(helm.Release)
+		return nil, err
+	}
+
+	values, err := chartutil.ReadValues([]byte(helm.Values))
+	if err != nil {
+		return nil, oops.Wrapf(err, "error parsing values")
+	}
+
+	history := action.NewHistory(cfg)
+	history.Max = 1
+	_, err = history.Run(helm.Release)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, oops.Wrapf(err, "error getting release %s", helm.Release)
+	}
+
+	var rel *release.Release
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		install := action.NewInstall(cfg)
+		install.ReleaseName = helm.Release
+		install.Namespace = helm.Namespace
+		install.CreateNamespace = true
+		install.Wait = helm.Wait
+		install.Timeout = helmTimeout(helm)
+		install.RepoURL = helm.Repo
+		install.Version = helm.Version
+		ch, err := loadChart(install.ChartPathOptions, helm.Chart)
+		if err != nil {
+			return nil, err
+		}
+		rel, err = install.RunWithContext(ctx, ch, values)
+		if err != nil {
+			return rel, err
+		}
+	} else {
+		upgrade := action.NewUpgrade(cfg)
+		upgrade.Namespace = helm.Namespace
+		upgrade.Wait = helm.Wait
+		upgrade.Timeout = helmTimeout(helm)
+		upgrade.RepoURL = helm.Repo
+		upgrade.Version = helm.Version
+		ch, err := loadChart(upgrade.ChartPathOptions, helm.Chart)
+		if err != nil {
+			return nil, err
+		}
+		rel, err = upgrade.RunWithContext(ctx, helm.Release, ch, values)
+		if err != nil {
+			return rel, err
+		}
+	}
+
+	if rel.Info.Status != release.StatusDeployed {
+		return rel, fmt.Errorf("release %s: %s", releaseName(rel), rel.Info.Description)
+	}
+	return rel, nil
+}
+
+func loadChart(options action.ChartPathOptions, name string) (*chart.Chart, error) {
+	path, err := options.LocateChart(name, cli.New())
+	if err != nil {
+		return nil, oops.Wrapf(err, "error locating chart %s", name)
+	}
+	loaded, err := loader.Load(path)
+	if err != nil {
+		return nil, oops.Wrapf(err, "error loading chart %s", name)
+	}
+	return loaded, nil
+}
+
+// releaseName describes a release, e.g. "envs/env-1 (revision=2, status=deployed)"
+func releaseName(rel *release.Release) string {
+	return fmt.Sprintf("%s/%s (revision=%d, status=%s)", rel.Namespace, rel.Name, rel.Version, rel.Info.Status)
+}
+
+// releaseObject returns the Secret that helm stores a revision of a release in, which is reported as the object
+// created for the message
+func releaseObject(ctx context.Context, client *dutyKubernetes.Client, rel *release.Release) metav1.Object {
+	name := fmt.Sprintf("sh.helm.release.v1.%s.v%d", rel.Name, rel.Version)
+	secret, err := client.CoreV1().Secrets(rel.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if !kerrors.IsNotFound(err) {
+			ctx.Warnf("Error getting release %s: %v", name, err)
+		}
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: rel.Namespace}}
+	}
+	return secret
+}
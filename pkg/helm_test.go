@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// fakeHelmConfiguration returns a helm configuration that keeps releases in memory and doesn't create the
+// rendered objects
+func fakeHelmConfiguration(t *testing.T) *action.Configuration {
+	return &action.Configuration{
+		Releases:     storage.Init(driver.NewMemory()),
+		KubeClient:   &kubefake.PrintingKubeClient{Out: io.Discard},
+		Capabilities: chartutil.DefaultCapabilities,
+		Log:          t.Logf,
+	}
+}
+
+// localChart writes a chart with a single ConfigMap to a temporary directory
+func localChart(t *testing.T) string {
+	dir := t.TempDir()
+	Expect(os.MkdirAll(filepath.Join(dir, "templates"), 0o755)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("apiVersion: v2\nname: env\nversion: 1.0.0\n"), 0o644)).To(Succeed())
+	configMap := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\ndata:\n  replicas: {{ .Values.replicas | quote }}\n"
+	Expect(os.WriteFile(filepath.Join(dir, "templates", "configmap.yaml"), []byte(configMap), 0o644)).To(Succeed())
+	return dir
+}
+
+func TestRunHelmAction(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	helm := v1.HelmAction{
+		Chart:     localChart(t),
+		Release:   "env-first",
+		Namespace: "envs",
+		Values:    "replicas: 1",
+	}
+
+	t.Run("installs and upgrades the release", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		cfg := fakeHelmConfiguration(t)
+		rel, err := runHelmAction(ctx, cfg, helm)
+		Expect(err).To(BeNil())
+		Expect(releaseName(rel)).To(Equal("envs/env-first (revision=1, status=deployed)"))
+		Expect(rel.Manifest).To(ContainSubstring(`replicas: "1"`))
+
+		upgrade := helm
+		upgrade.Values = "replicas: 2"
+		rel, err = runHelmAction(ctx, cfg, upgrade)
+		Expect(err).To(BeNil())
+		Expect(releaseName(rel)).To(Equal("envs/env-first (revision=2, status=deployed)"))
+		Expect(rel.Manifest).To(ContainSubstring(`replicas: "2"`))
+	})
+
+	t.Run("fails when the release is not deployed", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		cfg := fakeHelmConfiguration(t)
+		cfg.KubeClient = &kubefake.FailingKubeClient{
+			PrintingKubeClient: kubefake.PrintingKubeClient{Out: io.Discard},
+			WaitError:          errors.New("timed out waiting for the condition"),
+		}
+		wait := helm
+		wait.Wait = true
+		_, err := runHelmAction(ctx, cfg, wait)
+		Expect(err).To(MatchError(ContainSubstring("timed out")))
+
+		rel, err := cfg.Releases.Last("env-first")
+		Expect(err).To(BeNil())
+		Expect(rel.Info.Status).To(Equal(release.StatusFailed))
+	})
+
+	t.Run("uninstalls the release", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		cfg := fakeHelmConfiguration(t)
+		_, err := runHelmAction(ctx, cfg, helm)
+		Expect(err).To(BeNil())
+
+		uninstall := helm
+		uninstall.Uninstall = " true "
+		rel, err := runHelmAction(ctx, cfg, uninstall)
+		Expect(err).To(BeNil())
+		Expect(rel).To(BeNil())
+
+		_, err = cfg.Releases.Last("env-first")
+		Expect(errors.Is(err, driver.ErrReleaseNotFound)).To(BeTrue())
+
+		rel, err = runHelmAction(ctx, cfg, uninstall)
+		Expect(err).To(BeNil(), "uninstalling a missing release succeeds")
+		Expect(rel).To(BeNil())
+	})
+}
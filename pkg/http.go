@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"fmt"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/connection"
+	"github.com/flanksource/duty/context"
+	"github.com/samber/oops"
+)
+
+const defaultHTTPTimeout = time.Minute
+
+// HTTPResult is the response of an HTTP action
+type HTTPResult struct {
+	StatusCode int
+	Body       string
+}
+
+func (r HTTPResult) String() string {
+	return fmt.Sprintf("status=%d body=%s", r.StatusCode, r.Body)
+}
+
+// doHTTP sends the request described by an already templated action, returning an error if
+// the request fails or the response status is not expected
+func doHTTP(ctx context.Context, action v1.HTTPAction, body string) (*HTTPResult, error) {
+	conn, err := action.HTTPConnection.Hydrate(ctx, ctx.GetNamespace())
+	if err != nil {
+		return nil, oops.Wrapf(err, "error hydrating connection")
+	}
+	if conn.URL == "" {
+		return nil, fmt.Errorf("http action requires a url or connection")
+	}
+
+	client, err := connection.CreateHTTPClient(ctx, *conn)
+	if err != nil {
+		return nil, oops.Wrapf(err, "error creating http client")
+	}
+
+	timeout := defaultHTTPTimeout
+	if action.Timeout != nil {
+		timeout = action.Timeout.Duration
+	}
+	client.Timeout(timeout)
+
+	req := client.R(ctx)
+	for k, v := range action.Headers {
+		req.Header(k, v)
+	}
+	if action.Body != "" {
+		body = action.Body
+	}
+	if err := req.Body(body); err != nil {
+		return nil, err
+	}
+
+	resp, err := req.Do(action.GetMethod(), conn.URL)
+	if err != nil {
+		return nil, oops.Wrapf(err, "error sending %s %s", action.GetMethod(), conn.URL)
+	}
+
+	result := &HTTPResult{StatusCode: resp.StatusCode}
+	result.Body, _ = resp.AsString()
+	if !resp.IsOK(action.ExpectedStatus...) {
+		return result, fmt.Errorf("%s %s returned unexpected status: %s", action.GetMethod(), conn.URL, result)
+	}
+	return result, nil
+}
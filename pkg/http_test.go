@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/connection"
+	"github.com/flanksource/duty/context"
+	. "github.com/onsi/gomega"
+)
+
+func TestDoHTTP(t *testing.T) {
+	RegisterTestingT(t)
+
+	var method, header, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		header = r.Header.Get("X-Message")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		if r.URL.Path == "/accepted" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	ctx := context.New()
+
+	t.Run("defaults to posting the message", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		action := v1.HTTPAction{
+			HTTPConnection: connection.HTTPConnection{URL: server.URL},
+			Headers:        map[string]string{"X-Message": "first"},
+		}
+		result, err := doHTTP(ctx, action, `{"a":"first"}`)
+		Expect(err).To(BeNil())
+		Expect(result.StatusCode).To(Equal(http.StatusOK))
+		Expect(result.Body).To(Equal("ok"))
+		Expect(method).To(Equal(http.MethodPost))
+		Expect(header).To(Equal("first"))
+		Expect(body).To(Equal(`{"a":"first"}`))
+	})
+
+	t.Run("uses the templated body and method", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		action := v1.HTTPAction{
+			HTTPConnection: connection.HTTPConnection{URL: server.URL},
+			Method:         "put",
+			Body:           "second",
+		}
+		_, err := doHTTP(ctx, action, `{"a":"first"}`)
+		Expect(err).To(BeNil())
+		Expect(method).To(Equal(http.MethodPut))
+		Expect(body).To(Equal("second"))
+	})
+
+	t.Run("fails on an unexpected status", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		action := v1.HTTPAction{
+			HTTPConnection: connection.HTTPConnection{URL: server.URL + "/accepted"},
+			ExpectedStatus: []int{http.StatusOK},
+		}
+		result, err := doHTTP(ctx, action, "")
+		Expect(err).ToNot(BeNil())
+		Expect(result.StatusCode).To(Equal(http.StatusAccepted))
+	})
+}
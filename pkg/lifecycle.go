@@ -0,0 +1,45 @@
+package pkg
+
+import (
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// TriggerOwnerReference returns a non-controlling owner reference to the trigger
+func TriggerOwnerReference(trigger *metav1.ObjectMeta) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: v1.GroupVersion.String(),
+		Kind:       "BatchTrigger",
+		Name:       trigger.Name,
+		UID:        trigger.UID,
+	}
+}
+
+// applyLifecycle adds the trigger owner reference and the default Job TTL to obj as configured on the trigger
+func applyLifecycle(config *v1.Config, provenance Provenance, obj metav1.Object) {
+	trigger := provenance.Trigger
+	if config.OwnerReference && trigger != nil && trigger.UID != "" && obj.GetNamespace() == trigger.Namespace {
+		obj.SetOwnerReferences(append(obj.GetOwnerReferences(), TriggerOwnerReference(trigger)))
+	}
+
+	if config.TTLAfterFinished == nil {
+		return
+	}
+	ttl := int64(config.TTLAfterFinished.Seconds())
+	switch o := obj.(type) {
+	case *batchv1.Job:
+		if o.Spec.TTLSecondsAfterFinished == nil {
+			ttl32 := int32(ttl)
+			o.Spec.TTLSecondsAfterFinished = &ttl32
+		}
+	case *unstructured.Unstructured:
+		if o.GroupVersionKind().GroupKind() != batchv1.SchemeGroupVersion.WithKind("Job").GroupKind() {
+			return
+		}
+		if _, found, _ := unstructured.NestedFieldNoCopy(o.Object, "spec", "ttlSecondsAfterFinished"); !found {
+			_ = unstructured.SetNestedField(o.Object, ttl, "spec", "ttlSecondsAfterFinished")
+		}
+	}
+}
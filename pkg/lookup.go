@@ -0,0 +1,184 @@
+package pkg
+
+import (
+	"fmt"
+
+	"github.com/flanksource/duty/context"
+	dutyKubernetes "github.com/flanksource/duty/kubernetes"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/robertkrimen/otto"
+	"github.com/samber/oops"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ClusterLookup provides template functions that read ConfigMaps, Secrets and other objects from a single
+// namespace, using the RBAC permissions of batch-runner. Results are cached, so a ClusterLookup is created
+// for each message.
+type ClusterLookup struct {
+	ctx       context.Context
+	namespace string
+	client    kubernetes.Interface
+	resource  func(gvk schema.GroupVersionKind) (dynamic.NamespaceableResourceInterface, error)
+	cache     map[string]lookupResult
+}
+
+type lookupResult struct {
+	value any
+	err   error
+}
+
+// NewClusterLookup returns a lookup for namespace, which should be the namespace of the trigger
+func NewClusterLookup(ctx context.Context, client *dutyKubernetes.Client, namespace string) *ClusterLookup {
+	return &ClusterLookup{
+		ctx:       ctx,
+		namespace: namespace,
+		client:    client,
+		resource: func(gvk schema.GroupVersionKind) (dynamic.NamespaceableResourceInterface, error) {
+			return client.GetClientByGroupVersionKind(ctx, gvk.Group, gvk.Version, gvk.Kind)
+		},
+		cache: make(map[string]lookupResult),
+	}
+}
+
+func (l *ClusterLookup) cached(key string, fn func() (any, error)) (any, error) {
+	if r, ok := l.cache[key]; ok {
+		return r.value, r.err
+	}
+	value, err := fn()
+	l.cache[key] = lookupResult{value: value, err: err}
+	return value, err
+}
+
+// ConfigMap returns the value of key in a ConfigMap
+func (l *ClusterLookup) ConfigMap(name, key string) (string, error) {
+	data, err := l.cached("configmap/"+name, func() (any, error) {
+		cm, err := l.client.CoreV1().ConfigMaps(l.namespace).Get(l.ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, oops.Wrapf(err, "error looking up configmap %s/%s", l.namespace, name)
+		}
+		return cm.Data, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	value, ok := data.(map[string]string)[key]
+	if !ok {
+		return "", fmt.Errorf("configmap %s/%s has no key %s", l.namespace, name, key)
+	}
+	return value, nil
+}
+
+// Secret returns the decoded value of key in a Secret
+func (l *ClusterLookup) Secret(name, key string) (string, error) {
+	data, err := l.cached("secret/"+name, func() (any, error) {
+		secret, err := l.client.CoreV1().Secrets(l.namespace).Get(l.ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, oops.Wrapf(err, "error looking up secret %s/%s", l.namespace, name)
+		}
+		return secret.Data, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	value, ok := data.(map[string][]byte)[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no key %s", l.namespace, name, key)
+	}
+	return string(value), nil
+}
+
+// Lookup returns an object as a map, e.g. lookup "apps/v1" "Deployment" "api"
+func (l *ClusterLookup) Lookup(apiVersion, kind, name string) (map[string]any, error) {
+	obj, err := l.cached(fmt.Sprintf("%s/%s/%s", apiVersion, kind, name), func() (any, error) {
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := l.resource(gv.WithKind(kind))
+		if err != nil {
+			return nil, oops.Wrapf(err, "error looking up %s", kind)
+		}
+		obj, err := rc.Namespace(l.namespace).Get(l.ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, oops.Wrapf(err, "error looking up %s %s/%s", kind, l.namespace, name)
+		}
+		return obj.Object, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return obj.(map[string]any), nil
+}
+
+// goFuncs returns the functions for go templates
+func (l *ClusterLookup) goFuncs() map[string]any {
+	return map[string]any{
+		"configMap": l.ConfigMap,
+		"secret":    l.Secret,
+		"lookup":    l.Lookup,
+	}
+}
+
+// celEnvs returns the functions for CEL expressions
+func (l *ClusterLookup) celEnvs() []cel.EnvOption {
+	str := func(fn func(name, key string) (string, error)) func(name, key ref.Val) ref.Val {
+		return func(name, key ref.Val) ref.Val {
+			value, err := fn(fmt.Sprint(name.Value()), fmt.Sprint(key.Value()))
+			if err != nil {
+				return types.WrapErr(err)
+			}
+			return types.String(value)
+		}
+	}
+	return []cel.EnvOption{
+		cel.Function("configMap", cel.Overload("configMap_string_string",
+			[]*cel.Type{cel.StringType, cel.StringType}, cel.StringType, cel.BinaryBinding(str(l.ConfigMap)))),
+		cel.Function("secret", cel.Overload("secret_string_string",
+			[]*cel.Type{cel.StringType, cel.StringType}, cel.StringType, cel.BinaryBinding(str(l.Secret)))),
+		cel.Function("lookup", cel.Overload("lookup_string_string_string",
+			[]*cel.Type{cel.StringType, cel.StringType, cel.StringType}, cel.DynType,
+			cel.FunctionBinding(func(args ...ref.Val) ref.Val {
+				obj, err := l.Lookup(fmt.Sprint(args[0].Value()), fmt.Sprint(args[1].Value()), fmt.Sprint(args[2].Value()))
+				if err != nil {
+					return types.WrapErr(err)
+				}
+				return types.DefaultTypeAdapter.NativeToValue(obj)
+			}))),
+	}
+}
+
+// jsFuncs returns the functions for javascript, which throw on errors
+func (l *ClusterLookup) jsFuncs() map[string]any {
+	throw := func(err error) {
+		value, _ := otto.ToValue(err.Error())
+		panic(value)
+	}
+	return map[string]any{
+		"configMap": func(name, key string) string {
+			value, err := l.ConfigMap(name, key)
+			if err != nil {
+				throw(err)
+			}
+			return value
+		},
+		"secret": func(name, key string) string {
+			value, err := l.Secret(name, key)
+			if err != nil {
+				throw(err)
+			}
+			return value
+		},
+		"lookup": func(apiVersion, kind, name string) map[string]any {
+			obj, err := l.Lookup(apiVersion, kind, name)
+			if err != nil {
+				throw(err)
+			}
+			return obj
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"testing"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testLookup(ctx context.Context) (*ClusterLookup, *int) {
+	client := fake.NewClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a"}, Data: map[string]string{"region": "eu-west-1"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "team-a"}, Data: map[string][]byte{"password": []byte("hunter2")}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-b"}, Data: map[string]string{"region": "us-east-1"}},
+	)
+	deployment := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]any{"name": "api", "namespace": "team-a"},
+		"spec": map[string]any{"template": map[string]any{"spec": map[string]any{
+			"containers": []any{map[string]any{"name": "api", "image": "api:1.2.3"}},
+		}}},
+	}}
+	dyn := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), deployment)
+
+	lookups := 0
+	return &ClusterLookup{
+		ctx:       ctx,
+		namespace: "team-a",
+		client:    client,
+		resource: func(gvk schema.GroupVersionKind) (dynamic.NamespaceableResourceInterface, error) {
+			lookups++
+			return dyn.Resource(schema.GroupVersionResource{Group: gvk.Group, Version: gvk.Version, Resource: "deployments"}), nil
+		},
+		cache: make(map[string]lookupResult),
+	}, &lookups
+}
+
+func TestClusterLookup(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	values := map[string]any{"id": "abc"}
+
+	t.Run("go templates", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		lookup, lookups := testLookup(ctx)
+		templater, err := NewTemplater(ctx, nil, values, lookup)
+		Expect(err).To(BeNil())
+
+		out, err := templater.Template(`{{ configMap "settings" "region" }} {{ secret "db" "password" }}`)
+		Expect(err).To(BeNil())
+		Expect(out).To(Equal("eu-west-1 hunter2"))
+
+		out, err = templater.Template(`{{ (index (lookup "apps/v1" "Deployment" "api").spec.template.spec.containers 0).image }}`)
+		Expect(err).To(BeNil())
+		Expect(out).To(Equal("api:1.2.3"))
+
+		// results are cached for the message
+		_, err = templater.Template(`{{ (lookup "apps/v1" "Deployment" "api").metadata.name }}`)
+		Expect(err).To(BeNil())
+		Expect(*lookups).To(Equal(1))
+	})
+
+	t.Run("cel", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		lookup, _ := testLookup(ctx)
+		templater, err := NewTemplater(ctx, &v1.TemplateConfig{Engine: v1.TemplateEngineCEL}, values, lookup)
+		Expect(err).To(BeNil())
+
+		out, err := templater.Template(`$(configMap("settings", "region"))-$(lookup("apps/v1", "Deployment", "api").spec.template.spec.containers[0].image)`)
+		Expect(err).To(BeNil())
+		Expect(out).To(Equal("eu-west-1-api:1.2.3"))
+	})
+
+	t.Run("javascript", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		lookup, _ := testLookup(ctx)
+		templater, err := NewTemplater(ctx, &v1.TemplateConfig{Engine: v1.TemplateEngineJavascript}, values, lookup)
+		Expect(err).To(BeNil())
+
+		out, err := templater.Template(`$(secret("db", "password").toUpperCase())`)
+		Expect(err).To(BeNil())
+		Expect(out).To(Equal("HUNTER2"))
+
+		_, err = templater.Template(`$(secret("db", "username"))`)
+		Expect(err).To(MatchError(ContainSubstring("has no key username")))
+	})
+
+	t.Run("is scoped to the namespace", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		lookup, _ := testLookup(ctx)
+		lookup.namespace = "team-c"
+		_, err := lookup.ConfigMap("settings", "region")
+		Expect(err).To(MatchError(ContainSubstring("not found")))
+	})
+}
@@ -0,0 +1,221 @@
+package pkg
+
+import (
+	"strconv"
+	"time"
+
+	pb "cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"github.com/IBM/sarama"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/prometheus/client_golang/prometheus"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gocloud.dev/pubsub"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Connection states of a consumer
+const (
+	ConnectionConnected    = "Connected"
+	ConnectionError        = "Error"
+	ConnectionDisconnected = "Disconnected"
+)
+
+var connectionStates = []string{ConnectionConnected, ConnectionError, ConnectionDisconnected}
+
+var (
+	messagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "batch_runner",
+		Name:      "messages_received_total",
+		Help:      "Messages received from the queue, including redeliveries",
+	}, []string{"trigger"})
+	messagesProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "batch_runner",
+		Name:      "messages_processed_total",
+		Help:      "Messages whose action succeeded",
+	}, []string{"trigger"})
+	messagesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "batch_runner",
+		Name:      "messages_failed_total",
+		Help:      "Messages that failed and were acked without being retried",
+	}, []string{"trigger"})
+	messagesRetried = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "batch_runner",
+		Name:      "messages_retried_total",
+		Help:      "Messages that failed and will be redelivered",
+	}, []string{"trigger"})
+	messagesSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "batch_runner",
+		Name:      "messages_skipped_total",
+		Help:      "Processed messages whose object already existed and was kept by the skip conflict policy",
+	}, []string{"trigger"})
+
+	decodeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "batch_runner",
+		Name:      "decode_duration_seconds",
+		Help:      "Time to decode the body of a message",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+	}, []string{"trigger"})
+	renderDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "batch_runner",
+		Name:      "render_duration_seconds",
+		Help:      "Time to template the action of a message",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+	}, []string{"trigger"})
+	actionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "batch_runner",
+		Name:      "action_duration_seconds",
+		Help:      "Time to run the action of a message",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 3, 10),
+	}, []string{"trigger"})
+	endToEndDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "batch_runner",
+		Name:      "end_to_end_duration_seconds",
+		Help:      "Time from when a message was published until it was handled, for queues that record the publish time",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 3, 11),
+	}, []string{"trigger"})
+
+	messagesInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "batch_runner",
+		Name:      "messages_in_flight",
+		Help:      "Messages that are being handled",
+	}, []string{"trigger"})
+	connectionState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "batch_runner",
+		Name:      "connection_state",
+		Help:      "1 for the current state of the connection to the queue: Connected, Error or Disconnected",
+	}, []string{"trigger", "state"})
+)
+
+func init() {
+	// the registry of controller-runtime is served by the controller, and by the consumer with --metrics-bind-address
+	ctrlmetrics.Registry.MustRegister(
+		messagesReceived, messagesProcessed, messagesFailed, messagesRetried, messagesSkipped,
+		decodeDuration, renderDuration, actionDuration, endToEndDuration,
+		messagesInFlight, connectionState,
+	)
+}
+
+// DeleteTriggerMetrics deletes the series of a trigger, e.g. once it is deleted, so that they are no longer exported
+func DeleteTriggerMetrics(trigger string) {
+	labels := prometheus.Labels{"trigger": trigger}
+	for _, vec := range []interface{ DeletePartialMatch(prometheus.Labels) int }{
+		messagesReceived, messagesProcessed, messagesFailed, messagesRetried, messagesSkipped,
+		decodeDuration, renderDuration, actionDuration, endToEndDuration,
+		messagesInFlight, connectionState,
+	} {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// consumerMetrics are the metrics of the consumer of a trigger
+type consumerMetrics struct {
+	trigger string
+}
+
+func newConsumerMetrics(trigger string) *consumerMetrics {
+	return &consumerMetrics{trigger: trigger}
+}
+
+func (m *consumerMetrics) setConnectionState(state string) {
+	for _, s := range connectionStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		connectionState.WithLabelValues(m.trigger, s).Set(value)
+	}
+}
+
+// callbacks returns callbacks that count outcomes and record the connection state before calling the
+// callbacks of the consumer
+func (m *consumerMetrics) callbacks(callbacks *ConsumerCallbacks) *ConsumerCallbacks {
+	if callbacks == nil {
+		callbacks = &ConsumerCallbacks{}
+	}
+	wrapped := *callbacks
+	wrapped.OnMessageProcessed = func() {
+		messagesProcessed.WithLabelValues(m.trigger).Inc()
+		if callbacks.OnMessageProcessed != nil {
+			callbacks.OnMessageProcessed()
+		}
+	}
+	wrapped.OnMessageFailed = func(err error) {
+		messagesFailed.WithLabelValues(m.trigger).Inc()
+		if callbacks.OnMessageFailed != nil {
+			callbacks.OnMessageFailed(err)
+		}
+	}
+	wrapped.OnMessageRetried = func() {
+		messagesRetried.WithLabelValues(m.trigger).Inc()
+		if callbacks.OnMessageRetried != nil {
+			callbacks.OnMessageRetried()
+		}
+	}
+	wrapped.OnConnectionChange = func(state string) {
+		m.setConnectionState(state)
+		if callbacks.OnConnectionChange != nil {
+			callbacks.OnConnectionChange(state)
+		}
+	}
+	return &wrapped
+}
+
+// received counts a message and marks it in flight, the returned func is called once it has been handled
+func (m *consumerMetrics) received(msg *pubsub.Message) func(result ActionResult) {
+	messagesReceived.WithLabelValues(m.trigger).Inc()
+	inFlight := messagesInFlight.WithLabelValues(m.trigger)
+	inFlight.Inc()
+	return func(result ActionResult) {
+		inFlight.Dec()
+		if result.Skipped {
+			messagesSkipped.WithLabelValues(m.trigger).Inc()
+		}
+		if published, ok := publishTime(msg); ok {
+			endToEndDuration.WithLabelValues(m.trigger).Observe(time.Since(published).Seconds())
+		}
+	}
+}
+
+func (m *consumerMetrics) observeRender(rendered *Rendered, duration time.Duration) {
+	decodeDuration.WithLabelValues(m.trigger).Observe(rendered.DecodeDuration.Seconds())
+	renderDuration.WithLabelValues(m.trigger).Observe((duration - rendered.DecodeDuration).Seconds())
+}
+
+func (m *consumerMetrics) observeAction(duration time.Duration) {
+	actionDuration.WithLabelValues(m.trigger).Observe(duration.Seconds())
+}
+
+// publishTime returns when msg was published, for the queues that record it
+func publishTime(msg *pubsub.Message) (time.Time, bool) {
+	var sqsMessage sqstypes.Message
+	if messageAs(msg, &sqsMessage) {
+		if ms, err := strconv.ParseInt(sqsMessage.Attributes["SentTimestamp"], 10, 64); err == nil {
+			return time.UnixMilli(ms), true
+		}
+		return time.Time{}, false
+	}
+	var pubsubMessage *pb.PubsubMessage
+	if messageAs(msg, &pubsubMessage) && pubsubMessage.GetPublishTime() != nil {
+		return pubsubMessage.GetPublishTime().AsTime(), true
+	}
+	var kafkaMessage *sarama.ConsumerMessage
+	if messageAs(msg, &kafkaMessage) && !kafkaMessage.Timestamp.IsZero() {
+		return kafkaMessage.Timestamp, true
+	}
+	var delivery amqp.Delivery
+	if messageAs(msg, &delivery) && !delivery.Timestamp.IsZero() {
+		return delivery.Timestamp, true
+	}
+	return time.Time{}, false
+}
+
+// messageAs is msg.As, which panics for messages that were not received from a subscription, or whose
+// driver does not convert messages, e.g. memory queues
+func messageAs(msg *pubsub.Message, i any) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return msg.As(i)
+}
@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gocloud.dev/pubsub"
+)
+
+func TestConsumerMetrics(t *testing.T) {
+	RegisterTestingT(t)
+
+	trigger := "jobs/metrics"
+	metrics := newConsumerMetrics(trigger)
+
+	processed := 0
+	callbacks := metrics.callbacks(&ConsumerCallbacks{OnMessageProcessed: func() { processed++ }})
+
+	callbacks.OnConnectionChange(ConnectionConnected)
+	Expect(testutil.ToFloat64(connectionState.WithLabelValues(trigger, ConnectionConnected))).To(Equal(1.0))
+	Expect(testutil.ToFloat64(connectionState.WithLabelValues(trigger, ConnectionError))).To(Equal(0.0))
+
+	handled := metrics.received(&pubsub.Message{Body: []byte("{}")})
+	Expect(testutil.ToFloat64(messagesReceived.WithLabelValues(trigger))).To(Equal(1.0))
+	Expect(testutil.ToFloat64(messagesInFlight.WithLabelValues(trigger))).To(Equal(1.0))
+
+	callbacks.OnMessageProcessed()
+	handled(ActionResult{Skipped: true})
+	Expect(processed).To(Equal(1))
+	Expect(testutil.ToFloat64(messagesProcessed.WithLabelValues(trigger))).To(Equal(1.0))
+	Expect(testutil.ToFloat64(messagesSkipped.WithLabelValues(trigger))).To(Equal(1.0))
+	Expect(testutil.ToFloat64(messagesInFlight.WithLabelValues(trigger))).To(Equal(0.0))
+
+	callbacks.OnMessageRetried()
+	callbacks.OnMessageFailed(errors.New("exit status 1"))
+	Expect(testutil.ToFloat64(messagesRetried.WithLabelValues(trigger))).To(Equal(1.0))
+	Expect(testutil.ToFloat64(messagesFailed.WithLabelValues(trigger))).To(Equal(1.0))
+
+	metrics.setConnectionState(ConnectionDisconnected)
+	Expect(testutil.ToFloat64(connectionState.WithLabelValues(trigger, ConnectionConnected))).To(Equal(0.0))
+	Expect(testutil.ToFloat64(connectionState.WithLabelValues(trigger, ConnectionDisconnected))).To(Equal(1.0))
+
+	_, ok := publishTime(&pubsub.Message{})
+	Expect(ok).To(BeFalse())
+
+	newConsumerMetrics("jobs/other").setConnectionState(ConnectionConnected)
+	received, states := testutil.CollectAndCount(messagesReceived), testutil.CollectAndCount(connectionState)
+	DeleteTriggerMetrics(trigger)
+	Expect(testutil.CollectAndCount(messagesReceived)).To(Equal(received - 1))
+	Expect(testutil.CollectAndCount(connectionState)).To(Equal(states-len(connectionStates)), "series of other triggers are kept")
+}
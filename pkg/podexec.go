@@ -0,0 +1,177 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	dutyKubernetes "github.com/flanksource/duty/kubernetes"
+	"github.com/samber/lo"
+	"github.com/samber/oops"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// PodExecResult is the output of a command run in a pod
+type PodExecResult struct {
+	Pod       string
+	Container string
+	ExitCode  int
+	Stdout    string
+	Stderr    string
+}
+
+func (r PodExecResult) String() string {
+	return fmt.Sprintf("pod=%s container=%s exit=%d\nstdout:\n%s\nstderr:\n%s", r.Pod, r.Container, r.ExitCode, r.Stdout, r.Stderr)
+}
+
+// lineLogger logs each complete line written to it as the command produces output
+type lineLogger struct {
+	mu     sync.Mutex
+	logf   func(format string, args ...any)
+	prefix string
+	buf    bytes.Buffer
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf.Write(p)
+	for {
+		line, err := l.buf.ReadString('\n')
+		if err != nil {
+			// keep the partial line until the rest of it is written
+			l.buf.Reset()
+			l.buf.WriteString(line)
+			return len(p), nil
+		}
+		l.logf("[%s] %s", l.prefix, strings.TrimSuffix(line, "\n"))
+	}
+}
+
+// Flush logs any trailing output that did not end with a newline
+func (l *lineLogger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buf.Len() > 0 {
+		l.logf("[%s] %s", l.prefix, l.buf.String())
+		l.buf.Reset()
+	}
+}
+
+// selectPod returns the named pod, or the first running pod that matches the selector
+func selectPod(ctx context.Context, client kubernetes.Interface, action v1.PodExecAction) (*corev1.Pod, error) {
+	if action.Name != "" {
+		pod, err := client.CoreV1().Pods(action.Namespace).Get(ctx, action.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if pod.Status.Phase != corev1.PodRunning {
+			return nil, fmt.Errorf("pod %s/%s is %s", pod.Namespace, pod.Name, pod.Status.Phase)
+		}
+		return pod, nil
+	}
+
+	pods, err := client.CoreV1().Pods(action.Namespace).List(ctx, metav1.ListOptions{LabelSelector: action.Selector})
+	if err != nil {
+		return nil, oops.Wrapf(err, "error listing pods matching %s", action.Selector)
+	}
+	running := lo.Filter(pods.Items, func(p corev1.Pod, _ int) bool {
+		return p.Status.Phase == corev1.PodRunning && p.DeletionTimestamp == nil
+	})
+	if len(running) == 0 {
+		return nil, fmt.Errorf("no running pods in %s match %s", action.Namespace, action.Selector)
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].Name < running[j].Name })
+	return &running[0], nil
+}
+
+// runPodExec runs the command of an already templated action in its pod, returning the exit code of the
+// command in the result rather than as an error, in the same way as runExec
+func runPodExec(ctx context.Context, client *dutyKubernetes.Client, action v1.PodExecAction) (*PodExecResult, error) {
+	if (action.Name == "") == (action.Selector == "") {
+		return nil, fmt.Errorf("podExec requires one of name or selector")
+	}
+	if len(action.Command) == 0 {
+		return nil, fmt.Errorf("podExec requires a command")
+	}
+	action.Namespace = lo.CoalesceOrEmpty(action.Namespace, ctx.GetNamespace(), "default")
+
+	pod, err := selectPod(ctx, client, action)
+	if err != nil {
+		return nil, err
+	}
+	container := action.Container
+	if container == "" {
+		container = pod.Spec.Containers[0].Name
+	}
+
+	req := client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(pod.Name).
+		Namespace(pod.Namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   action.Command,
+			Stdin:     action.Stdin != "",
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(client.Config, "POST", req.URL())
+	if err != nil {
+		return nil, oops.Wrapf(err, "error creating executor for %s/%s", pod.Namespace, pod.Name)
+	}
+
+	runCtx := ctx
+	if action.Timeout != nil && action.Timeout.Duration > 0 {
+		var cancel func()
+		runCtx, cancel = ctx.WithTimeout(action.Timeout.Duration)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	stdoutLog := &lineLogger{logf: ctx.Infof, prefix: "stdout"}
+	stderrLog := &lineLogger{logf: ctx.Infof, prefix: "stderr"}
+	opts := remotecommand.StreamOptions{
+		Stdout: io.MultiWriter(&stdout, stdoutLog),
+		Stderr: io.MultiWriter(&stderr, stderrLog),
+	}
+	if action.Stdin != "" {
+		opts.Stdin = strings.NewReader(action.Stdin)
+	}
+
+	err = executor.StreamWithContext(runCtx, opts)
+	stdoutLog.Flush()
+	stderrLog.Flush()
+
+	result := &PodExecResult{
+		Pod:       pod.Namespace + "/" + pod.Name,
+		Container: container,
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+	}
+	var exitErr utilexec.ExitError
+	switch {
+	case err == nil:
+		return result, nil
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+		return result, nil
+	case runCtx.Err() != nil && ctx.Err() == nil:
+		return result, TimeoutError{Timeout: action.Timeout.Duration}
+	default:
+		return result, oops.Wrapf(err, "error running command in %s", result.Pod)
+	}
+}
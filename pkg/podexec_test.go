@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"fmt"
+	"testing"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testPod(name string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "psql"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "psql"}}},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestSelectPod(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	client := fake.NewClientset(
+		testPod("psql-c", corev1.PodRunning),
+		testPod("psql-a", corev1.PodPending),
+		testPod("psql-b", corev1.PodRunning),
+	)
+
+	t.Run("selects the first running pod matching the selector", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		pod, err := selectPod(ctx, client, v1.PodExecAction{Namespace: "default", Selector: "app=psql"})
+		Expect(err).To(BeNil())
+		Expect(pod.Name).To(Equal("psql-b"))
+	})
+
+	t.Run("fails when no pods are running", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		_, err := selectPod(ctx, client, v1.PodExecAction{Namespace: "default", Selector: "app=mysql"})
+		Expect(err).To(MatchError(ContainSubstring("no running pods")))
+	})
+
+	t.Run("selects a pod by name", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		pod, err := selectPod(ctx, client, v1.PodExecAction{Namespace: "default", Name: "psql-c"})
+		Expect(err).To(BeNil())
+		Expect(pod.Name).To(Equal("psql-c"))
+
+		_, err = selectPod(ctx, client, v1.PodExecAction{Namespace: "default", Name: "psql-a"})
+		Expect(err).To(MatchError(ContainSubstring("is Pending")))
+	})
+}
+
+func TestLineLogger(t *testing.T) {
+	RegisterTestingT(t)
+
+	var lines []string
+	l := &lineLogger{prefix: "stdout", logf: func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}}
+	_, _ = l.Write([]byte("first\nsec"))
+	_, _ = l.Write([]byte("ond\nthird"))
+	Expect(lines).To(Equal([]string{"[stdout] first", "[stdout] second"}))
+
+	l.Flush()
+	Expect(lines).To(Equal([]string{"[stdout] first", "[stdout] second", "[stdout] third"}))
+}
@@ -0,0 +1,203 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	gocontext "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	dutyKubernetes "github.com/flanksource/duty/kubernetes"
+	"github.com/samber/lo"
+	"github.com/samber/oops"
+	"gocloud.dev/pubsub"
+)
+
+// ProcessOptions controls how ProcessMessages runs the messages of a file
+type ProcessOptions struct {
+	// Source names the messages in the report and logs, e.g. the name of the file
+	Source      string
+	Concurrency int
+	// DryRun renders each message without running the action
+	DryRun bool
+}
+
+// ProcessResult is the report of a single message
+type ProcessResult struct {
+	Line    int    `json:"line"`
+	ID      string `json:"id"`
+	Created string `json:"created,omitempty"`
+	// Skipped is true when the created object already existed and was kept by the skip conflict policy
+	Skipped  bool   `json:"skipped,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+	// Rendered is the templated action in a dry run
+	Rendered *Rendered `json:"rendered,omitempty"`
+}
+
+// ProcessMessages runs the action of config for each line of input, which is the body of a message, without
+// receiving them from a queue, e.g. to backfill historical messages. A result is written to report as JSON for
+// each message in the order of input, and the number of messages that failed is returned. Failed messages are
+// not retried.
+func ProcessMessages(ctx context.Context, config *v1.Config, input io.Reader, report io.Writer, opts ProcessOptions) (int, error) {
+	if config.GetDestination() == nil {
+		return 0, errNoAction
+	}
+	if _, err := templateDelims(config.Template); err != nil {
+		return 0, oops.Wrapf(err, "Invalid config")
+	}
+
+	client, topic, closeTopic, err := openAction(ctx, config, opts.DryRun)
+	if err != nil {
+		return 0, err
+	}
+	defer closeTopic()
+
+	// seq numbers the messages so that results are written in the order of the input
+	type line struct {
+		seq    int
+		number int
+		body   []byte
+	}
+	type result struct {
+		seq int
+		ProcessResult
+	}
+	lines := make(chan line)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(opts.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for l := range lines {
+				msg := &pubsub.Message{LoggableID: fmt.Sprintf("%s:%d", opts.Source, l.number), Body: l.body, Metadata: map[string]string{}}
+				results <- result{seq: l.seq, ProcessResult: processMessage(ctx, client, config, topic, opts.DryRun, l.number, msg)}
+			}
+		}()
+	}
+
+	var readErr error
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(input)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		seq := 0
+		for n := 1; scanner.Scan() && ctx.Err() == nil; n++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			lines <- line{seq: seq, number: n, body: append([]byte(nil), scanner.Bytes()...)}
+			seq++
+		}
+		readErr = scanner.Err()
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	failed := 0
+	next := 0
+	pending := map[int]ProcessResult{}
+	encoder := json.NewEncoder(report)
+	encoder.SetEscapeHTML(false)
+	var writeErr error
+	for r := range results {
+		if r.Error != "" {
+			failed++
+		}
+		pending[r.seq] = r.ProcessResult
+		for {
+			p, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if writeErr == nil {
+				writeErr = encoder.Encode(p)
+			}
+		}
+	}
+	if writeErr != nil {
+		return failed, oops.Wrapf(writeErr, "error writing report")
+	}
+	if readErr != nil {
+		return failed, oops.Wrapf(readErr, "error reading %s", opts.Source)
+	}
+	return failed, ctx.Err()
+}
+
+// openAction returns what the action of config needs to run outside of a consumer: a Kubernetes client, which
+// is optional unless the action needs a cluster, and the topic of a publish action, which is closed by the
+// returned func
+func openAction(ctx context.Context, config *v1.Config, dryRun bool) (*dutyKubernetes.Client, *pubsub.Topic, func(), error) {
+	client, err := ctx.LocalKubernetes()
+	if err != nil {
+		if !dryRun && needsCluster(config) {
+			return nil, nil, nil, oops.Wrapf(err, "Error getting Kubernetes client")
+		}
+		client = nil
+	}
+
+	if config.Publish == nil || dryRun {
+		return client, nil, func() {}, nil
+	}
+	topic, err := OpenTopic(ctx, config.Publish.QueueConfig, config.Publish.TopicConfig)
+	if err != nil {
+		return nil, nil, nil, oops.Wrapf(err, "Error opening %s", config.Publish)
+	}
+	return client, topic, func() {
+		if err := topic.Shutdown(gocontext.Background()); err != nil {
+			ctx.Errorf("Error closing %s: %v", config.Publish, err)
+		}
+	}, nil
+}
+
+// needsCluster returns true if the action of config creates objects or execs into pods
+func needsCluster(config *v1.Config) bool {
+	return config.Pod != nil || config.Job != nil || config.Resources != nil || config.PodExec != nil
+}
+
+func processMessage(ctx context.Context, client *dutyKubernetes.Client, config *v1.Config, topic *pubsub.Topic, dryRun bool, number int, msg *pubsub.Message) ProcessResult {
+	start := time.Now()
+	ctx = ctx.WithName(msg.LoggableID)
+	result := ProcessResult{Line: number, ID: msg.LoggableID}
+
+	var lookup *ClusterLookup
+	if client != nil {
+		lookup = NewClusterLookup(ctx, client, lo.CoalesceOrEmpty(ctx.GetNamespace(), "default"))
+	}
+	rendered, err := Render(ctx, config, msg, start, lookup)
+	if err != nil {
+		ctx.Errorf("Error rendering message: %v", err)
+		result.Error = err.Error()
+	} else if dryRun {
+		result.Rendered = rendered
+	} else {
+		action, err := RunAction(ctx, client, config, rendered, topic, nil)
+		if err != nil {
+			if action.Object != nil {
+				ctx.Errorf("Error creating %s: %v", action.Created, err)
+			}
+			result.Error = err.Error()
+		} else {
+			if action.Skipped {
+				ctx.Infof("Skipped %s as it already exists", action.Created)
+			} else if action.Object != nil {
+				ctx.Infof("Created %s", action.Created)
+			}
+			result.Created = action.Created
+			result.Skipped = action.Skipped
+		}
+	}
+	result.Duration = time.Since(start).String()
+	return result
+}
@@ -0,0 +1,75 @@
+//go:build !windows
+
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	. "github.com/onsi/gomega"
+)
+
+func TestProcessMessages(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	// the script fails for n=2, and sleeps so that earlier lines finish last
+	config := &v1.Config{Exec: &v1.ExecAction{Script: "sleep 0.{{ sub 4 .n }} && test {{.n}} != 2"}}
+	input := "{\"n\":1}\n\n{\"n\":2}\n{\"n\":3}\n"
+
+	report := func(buf *bytes.Buffer) []ProcessResult {
+		var results []ProcessResult
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var r ProcessResult
+			Expect(json.Unmarshal([]byte(line), &r)).To(Succeed())
+			results = append(results, r)
+		}
+		return results
+	}
+
+	t.Run("reports each line in order", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		var out bytes.Buffer
+		failed, err := ProcessMessages(ctx, config, strings.NewReader(input), &out, ProcessOptions{Source: "messages.jsonl", Concurrency: 3})
+		Expect(err).To(BeNil())
+		Expect(failed).To(Equal(1))
+
+		results := report(&out)
+		Expect(results).To(HaveLen(3))
+		Expect(results[0].Line).To(Equal(1))
+		Expect(results[0].ID).To(Equal("messages.jsonl:1"))
+		Expect(results[0].Created).To(Equal("script exited with 0"))
+		Expect(results[0].Error).To(BeEmpty())
+		Expect(results[1].Line).To(Equal(3))
+		Expect(results[1].Error).ToNot(BeEmpty())
+		Expect(results[1].Created).To(BeEmpty())
+		Expect(results[2].Line).To(Equal(4))
+		Expect(results[2].Duration).ToNot(BeEmpty())
+	})
+
+	t.Run("renders without running in a dry run", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		var out bytes.Buffer
+		failed, err := ProcessMessages(ctx, config, strings.NewReader(input), &out, ProcessOptions{DryRun: true})
+		Expect(err).To(BeNil())
+		Expect(failed).To(Equal(0))
+
+		results := report(&out)
+		Expect(results).To(HaveLen(3))
+		Expect(results[1].Rendered.Exec.Script).To(Equal("sleep 0.2 && test 2 != 2"))
+		Expect(results[1].Created).To(BeEmpty())
+	})
+
+	t.Run("requires an action", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		_, err := ProcessMessages(ctx, &v1.Config{}, strings.NewReader(input), &bytes.Buffer{}, ProcessOptions{})
+		Expect(err).To(Equal(errNoAction))
+	})
+}
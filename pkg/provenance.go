@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	"gocloud.dev/pubsub"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	LabelTrigger          = v1.Group + "/trigger"
+	LabelTriggerNamespace = v1.Group + "/trigger-namespace"
+	LabelMessageID        = v1.Group + "/message-id"
+
+	AnnotationMessageID   = v1.Group + "/message-id"
+	AnnotationAttempt     = v1.Group + "/attempt"
+	AnnotationReceived    = v1.Group + "/received"
+	AnnotationPayloadHash = v1.Group + "/payload-sha256"
+)
+
+// Provenance links created objects back to the trigger and message that created them
+type Provenance struct {
+	Trigger     *metav1.ObjectMeta
+	MessageID   string
+	Attempt     int
+	Received    time.Time
+	PayloadHash string
+}
+
+// TriggerFromContext returns the BatchTrigger metadata the consumer was started with, if any
+func TriggerFromContext(ctx context.Context) *metav1.ObjectMeta {
+	for _, o := range context.Objects(ctx) {
+		if meta, ok := o.(metav1.ObjectMeta); ok {
+			return &meta
+		}
+	}
+	return nil
+}
+
+// triggerName returns the namespace/name of the BatchTrigger of a consumer, or its queue when running
+// without the controller
+func triggerName(ctx context.Context, config *v1.Config) string {
+	if trigger := TriggerFromContext(ctx); trigger != nil {
+		return trigger.Namespace + "/" + trigger.Name
+	} else if queue := config.GetQueue(); queue != nil {
+		return queue.String()
+	}
+	return ""
+}
+
+func NewProvenance(ctx context.Context, msg *pubsub.Message, received time.Time) Provenance {
+	sum := sha256.Sum256(msg.Body)
+	return Provenance{
+		Trigger:     TriggerFromContext(ctx),
+		MessageID:   msg.LoggableID,
+		Attempt:     retry.Attempt(ctx, msg.LoggableID),
+		Received:    received,
+		PayloadHash: hex.EncodeToString(sum[:]),
+	}
+}
+
+// UID returns a short identifier of the message that is valid in object names, and stable across
+// redeliveries so that names built from it stay idempotent
+func (p Provenance) UID() string {
+	id := p.MessageID
+	if id == "" {
+		id = p.PayloadHash
+	}
+	if p.Trigger != nil {
+		id = p.Trigger.Namespace + "/" + p.Trigger.Name + "/" + id
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+// TemplateValues returns the trigger, received, attempt and uid fields of the template context
+func (p Provenance) TemplateValues() map[string]any {
+	trigger := map[string]any{"name": "", "namespace": "", "labels": map[string]any{}}
+	if p.Trigger != nil {
+		labels := make(map[string]any, len(p.Trigger.Labels))
+		for k, v := range p.Trigger.Labels {
+			labels[k] = v
+		}
+		trigger = map[string]any{"name": p.Trigger.Name, "namespace": p.Trigger.Namespace, "labels": labels}
+	}
+	return map[string]any{
+		"trigger":  trigger,
+		"received": p.Received.UTC().Format(time.RFC3339),
+		"attempt":  p.Attempt,
+		"uid":      p.UID(),
+	}
+}
+
+// TriggerLabels returns the labels that are the same for every message of a trigger
+func (p Provenance) TriggerLabels() map[string]string {
+	labels := map[string]string{}
+	if p.Trigger != nil {
+		labels[LabelTrigger] = p.Trigger.Name
+		labels[LabelTriggerNamespace] = p.Trigger.Namespace
+	}
+	return labels
+}
+
+func (p Provenance) Labels() map[string]string {
+	labels := p.TriggerLabels()
+	// message ids that are not valid label values are only recorded as an annotation
+	if p.MessageID != "" && len(validation.IsValidLabelValue(p.MessageID)) == 0 {
+		labels[LabelMessageID] = p.MessageID
+	}
+	return labels
+}
+
+func (p Provenance) Annotations() map[string]string {
+	return map[string]string{
+		AnnotationMessageID:   p.MessageID,
+		AnnotationAttempt:     strconv.Itoa(p.Attempt),
+		AnnotationReceived:    p.Received.UTC().Format(time.RFC3339),
+		AnnotationPayloadHash: p.PayloadHash,
+	}
+}
+
+// Apply adds the provenance labels and annotations to obj, overriding any existing values
+func (p Provenance) Apply(obj metav1.Object) {
+	obj.SetLabels(mergeMaps(obj.GetLabels(), p.Labels()))
+	obj.SetAnnotations(mergeMaps(obj.GetAnnotations(), p.Annotations()))
+}
+
+// ApplyTemplate adds the provenance labels to a pod template, so that pods created from it can be selected.
+// In apply mode only the trigger labels are added, as the pod template of an existing Job is immutable and
+// would otherwise change with every message.
+func (p Provenance) ApplyTemplate(template *corev1.PodTemplateSpec, mode v1.Mode) {
+	if mode == v1.ModeApply {
+		template.Labels = mergeMaps(template.Labels, p.TriggerLabels())
+		return
+	}
+	template.Labels = mergeMaps(template.Labels, p.Labels())
+}
+
+func mergeMaps(dst, src map[string]string) map[string]string {
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	. "github.com/onsi/gomega"
+	"gocloud.dev/pubsub"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProvenance(t *testing.T) {
+	RegisterTestingT(t)
+
+	trigger := metav1.ObjectMeta{Name: "sync", Namespace: "jobs"}
+	ctx := context.New().WithObject(trigger)
+	received := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("labels and annotates objects", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		p := NewProvenance(ctx, &pubsub.Message{LoggableID: "b3c8f3f2-1d2e-4b51-9a53-3c1c6d7c1d4e", Body: []byte(`{"a":"b"}`)}, received)
+
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "batch", Labels: map[string]string{"app": "sync"}}}
+		p.Apply(job)
+		p.ApplyTemplate(&job.Spec.Template, v1.ModeCreate)
+
+		Expect(job.Labels).To(HaveKeyWithValue("app", "sync"))
+		Expect(job.Labels).To(HaveKeyWithValue(LabelTrigger, "sync"))
+		Expect(job.Labels).To(HaveKeyWithValue(LabelTriggerNamespace, "jobs"))
+		Expect(job.Labels).To(HaveKeyWithValue(LabelMessageID, "b3c8f3f2-1d2e-4b51-9a53-3c1c6d7c1d4e"))
+		Expect(job.Spec.Template.Labels).To(HaveKeyWithValue(LabelTrigger, "sync"))
+		Expect(job.Spec.Template.Labels).To(HaveKey(LabelMessageID))
+		Expect(job.Annotations).To(HaveKeyWithValue(AnnotationAttempt, "1"))
+		Expect(job.Annotations).To(HaveKeyWithValue(AnnotationReceived, "2024-01-02T03:04:05Z"))
+		Expect(job.Annotations[AnnotationPayloadHash]).To(HaveLen(64))
+	})
+
+	t.Run("keeps the message off the pod template in apply mode", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		job := &batchv1.Job{}
+		NewProvenance(ctx, &pubsub.Message{LoggableID: "msg-1"}, received).ApplyTemplate(&job.Spec.Template, v1.ModeApply)
+		Expect(job.Spec.Template.Labels).To(Equal(map[string]string{LabelTrigger: "sync", LabelTriggerNamespace: "jobs"}))
+	})
+
+	t.Run("invalid label values are only annotated", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		p := NewProvenance(ctx, &pubsub.Message{LoggableID: "partition:0/offset:12"}, received)
+		Expect(p.Labels()).ToNot(HaveKey(LabelMessageID))
+		Expect(p.Annotations()).To(HaveKeyWithValue(AnnotationMessageID, "partition:0/offset:12"))
+	})
+
+	t.Run("counts retried attempts", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		msg := &pubsub.Message{LoggableID: "retried"}
+		retry.RecordAttempt(ctx, msg.LoggableID)
+		defer retry.Remove(ctx, msg.LoggableID)
+
+		Expect(NewProvenance(ctx, msg, received).Attempt).To(Equal(2))
+	})
+
+	t.Run("adds the template context", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		t.Setenv("CLUSTER_NAME", "prod")
+		labelled := context.New().WithObject(metav1.ObjectMeta{Name: "sync", Namespace: "jobs", Labels: map[string]string{"team": "a"}})
+		p := NewProvenance(labelled, &pubsub.Message{LoggableID: "msg-1"}, received)
+
+		values := map[string]any{"uid": "from the message"}
+		AddTemplateContext(labelled, values, p, &v1.TemplateConfig{Env: []string{"CLUSTER_NAME", "UNSET_VARIABLE"}})
+		Expect(values).To(HaveKeyWithValue("trigger", map[string]any{"name": "sync", "namespace": "jobs", "labels": map[string]any{"team": "a"}}))
+		Expect(values).To(HaveKeyWithValue("received", "2024-01-02T03:04:05Z"))
+		Expect(values).To(HaveKeyWithValue("attempt", 1))
+		Expect(values).To(HaveKeyWithValue("env", map[string]any{"CLUSTER_NAME": "prod", "UNSET_VARIABLE": ""}))
+		Expect(values).To(HaveKeyWithValue("uid", "from the message"), "message fields are not replaced")
+
+		batch := values["_batch"].(map[string]any)
+		Expect(batch["uid"]).To(MatchRegexp("^[0-9a-f]{10}$"))
+		Expect(batch).To(HaveKeyWithValue("attempt", 1))
+		Expect(batch).To(HaveKeyWithValue("env", values["env"]))
+	})
+
+	t.Run("uid is stable for a message", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		first := NewProvenance(ctx, &pubsub.Message{LoggableID: "msg-1"}, received)
+		redelivered := NewProvenance(ctx, &pubsub.Message{LoggableID: "msg-1"}, received.Add(time.Hour))
+		other := NewProvenance(ctx, &pubsub.Message{LoggableID: "msg-2"}, received)
+		Expect(first.UID()).To(Equal(redelivered.UID()))
+		Expect(first.UID()).ToNot(Equal(other.UID()))
+	})
+}
@@ -0,0 +1,179 @@
+package pkg
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+
+	"github.com/IBM/sarama"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	dutyps "github.com/flanksource/duty/pubsub"
+	"github.com/nats-io/nats.go"
+	"github.com/samber/lo"
+	"gocloud.dev/gcp"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/awssnssqs"
+	"gocloud.dev/pubsub/gcppubsub"
+	"gocloud.dev/pubsub/kafkapubsub"
+	"gocloud.dev/pubsub/natspubsub"
+	"golang.org/x/oauth2"
+)
+
+// OpenTopic opens the queue described by c for sending, it is the counterpart of dutyps.Subscribe.
+// SQS queues can also be SNS topics. GCP Pub/Sub and RabbitMQ publish to the topic and exchange of t, as the
+// subscription and queue of c can only be received from.
+func OpenTopic(ctx context.Context, c dutyps.QueueConfig, t v1.TopicConfig) (*pubsub.Topic, error) {
+	if c.SQS != nil {
+		if err := c.SQS.AWSConnection.Populate(ctx); err != nil {
+			return nil, err
+		}
+		sess, err := c.SQS.AWSConnection.Client(ctx)
+		if err != nil {
+			return nil, err
+		}
+		arn, err := ParseArn(c.SQS.QueueArn)
+		if err != nil {
+			return nil, err
+		}
+
+		if arn.Service == "sns" {
+			client := sns.NewFromConfig(sess, func(o *sns.Options) {
+				if c.SQS.Endpoint != "" {
+					o.BaseEndpoint = &c.SQS.Endpoint
+				}
+			})
+			return awssnssqs.OpenSNSTopicV2(ctx, client, c.SQS.QueueArn, nil), nil
+		}
+
+		client := sqs.NewFromConfig(sess, func(o *sqs.Options) {
+			if c.SQS.Endpoint != "" {
+				o.BaseEndpoint = &c.SQS.Endpoint
+			}
+		})
+		return awssnssqs.OpenSQSTopicV2(ctx, client, arn.ToQueueURL(), nil), nil
+	}
+
+	if c.PubSub != nil {
+		if c.PubSub.ProjectID == "" || t.Topic == "" {
+			return nil, fmt.Errorf("project_id and topic are required to publish to GCP Pub/Sub")
+		}
+
+		var tokenSrc oauth2.TokenSource
+		if c.PubSub.ConnectionName != "" {
+			if err := c.PubSub.GCPConnection.HydrateConnection(ctx); err != nil {
+				return nil, fmt.Errorf("error hydrating connection %s: %w", c.PubSub.ConnectionName, err)
+			}
+			var err error
+			if tokenSrc, err = c.PubSub.GCPConnection.TokenSource(ctx); err != nil {
+				return nil, fmt.Errorf("error getting token source for %s: %w", c.PubSub.ProjectID, err)
+			}
+		} else {
+			creds, err := gcp.DefaultCredentials(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("error creating default creds for %s: %w", c.PubSub.ProjectID, err)
+			}
+			tokenSrc = creds.TokenSource
+		}
+
+		conn, _, err := gcppubsub.Dial(ctx, tokenSrc)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to GCP: %w", err)
+		}
+		client, err := gcppubsub.PublisherClient(ctx, conn)
+		if err != nil {
+			return nil, fmt.Errorf("error creating publisher for %s: %w", c.PubSub.ProjectID, err)
+		}
+		return gcppubsub.OpenTopicByPath(client, fmt.Sprintf("projects/%s/topics/%s", c.PubSub.ProjectID, t.Topic), nil)
+	}
+
+	if c.Kafka != nil {
+		config := kafkapubsub.MinimalConfig()
+		if t.KafkaVersion != "" {
+			version, err := sarama.ParseKafkaVersion(t.KafkaVersion)
+			if err != nil {
+				return nil, fmt.Errorf("invalid kafkaVersion %s: %w", t.KafkaVersion, err)
+			}
+			config.Version = version
+		}
+		return kafkapubsub.OpenTopic(c.Kafka.Brokers, config, lo.CoalesceOrEmpty(t.Topic, c.Kafka.Topic), &kafkapubsub.TopicOptions{KeyName: t.KeyName})
+	}
+
+	if c.RabbitMQ != nil {
+		if t.Exchange == "" {
+			return nil, fmt.Errorf("exchange is required to publish to RabbitMQ")
+		}
+		query := url.Values{}
+		if t.KeyName != "" {
+			query.Set("key_name", t.KeyName)
+		}
+		return pubsub.OpenTopic(ctx, (&url.URL{Scheme: "rabbit", Host: t.Exchange, RawQuery: query.Encode()}).String())
+	}
+
+	if c.NATS != nil {
+		conn, err := nats.Connect(c.NATS.URL)
+		if err != nil {
+			return nil, err
+		}
+		return natspubsub.OpenTopicV2(conn, c.NATS.Subject, nil)
+	}
+
+	if c.Memory != nil {
+		return pubsub.OpenTopic(ctx, fmt.Sprintf("mem://%s", c.Memory.QueueName))
+	}
+
+	return nil, fmt.Errorf("no queue configuration provided")
+}
+
+// renderPublish returns the message to forward for msg, templating the body and metadata of the action
+func renderPublish(action *v1.PublishAction, templater Templater, msg *pubsub.Message) (*pubsub.Message, error) {
+	out := &pubsub.Message{
+		Body:     msg.Body,
+		Metadata: map[string]string{},
+	}
+	for k, v := range msg.Metadata {
+		out.Metadata[k] = v
+	}
+
+	if action.Body != "" {
+		body, err := templater.Template(action.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error templating body: %w", err)
+		}
+		out.Body = []byte(body)
+	}
+
+	for k, v := range action.Metadata {
+		value, err := templater.Template(v)
+		if err != nil {
+			return nil, fmt.Errorf("error templating metadata %s: %w", k, err)
+		}
+		out.Metadata[k] = value
+	}
+	return out, nil
+}
+
+// SendMessages sends count messages with body and metadata to the queue of a trigger, e.g. to test it end to end
+func SendMessages(ctx context.Context, queue dutyps.QueueConfig, destination v1.TopicConfig, body []byte, metadata map[string]string, count int) error {
+	topic, err := OpenTopic(ctx, queue, destination)
+	if err != nil {
+		return fmt.Errorf("error opening topic: %w", err)
+	}
+
+	for i := 0; i < count; i++ {
+		msg := &pubsub.Message{Body: body, Metadata: maps.Clone(metadata)}
+		if err := topic.Send(ctx, msg); err != nil {
+			_ = topic.Shutdown(ctx)
+			return fmt.Errorf("error sending message %d to %s: %w", i+1, queue.GetQueue(), err)
+		}
+	}
+
+	// batched topics send any remaining messages when they are shut down
+	if err := topic.Shutdown(ctx); err != nil {
+		return fmt.Errorf("error sending messages to %s: %w", queue.GetQueue(), err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"testing"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	dutyps "github.com/flanksource/duty/pubsub"
+	"github.com/flanksource/gomplate/v3"
+	. "github.com/onsi/gomega"
+	"gocloud.dev/pubsub"
+)
+
+func TestPublish(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	templater := gomplate.StructTemplater{
+		Context:   ctx.Context,
+		Values:    map[string]any{"a": "first"},
+		DelimSets: []gomplate.Delims{{Left: "{{", Right: "}}"}},
+	}
+	received := &pubsub.Message{Body: []byte(`{"a":"first"}`), Metadata: map[string]string{"source": "kafka"}}
+
+	t.Run("forwards the received message by default", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		out, err := renderPublish(&v1.PublishAction{}, templater, received)
+		Expect(err).To(BeNil())
+		Expect(string(out.Body)).To(Equal(`{"a":"first"}`))
+		Expect(out.Metadata).To(Equal(map[string]string{"source": "kafka"}))
+	})
+
+	t.Run("templates the body and metadata", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		action := &v1.PublishAction{
+			Body:     `{"b": "{{.a}}"}`,
+			Metadata: map[string]string{"type": "{{.a}}"},
+		}
+		out, err := renderPublish(action, templater, received)
+		Expect(err).To(BeNil())
+		Expect(string(out.Body)).To(Equal(`{"b": "first"}`))
+		Expect(out.Metadata).To(Equal(map[string]string{"source": "kafka", "type": "first"}))
+	})
+
+	t.Run("sends to the memory queue", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		topic, err := OpenTopic(ctx, dutyps.QueueConfig{Memory: &dutyps.MemoryConfig{QueueName: "publish-test"}}, v1.TopicConfig{})
+		Expect(err).To(BeNil())
+		defer topic.Shutdown(ctx)
+
+		sub, err := pubsub.OpenSubscription(ctx, "mem://publish-test")
+		Expect(err).To(BeNil())
+		defer sub.Shutdown(ctx)
+
+		Expect(topic.Send(ctx, &pubsub.Message{Body: []byte("hello")})).To(Succeed())
+		msg, err := sub.Receive(ctx)
+		Expect(err).To(BeNil())
+		Expect(string(msg.Body)).To(Equal("hello"))
+		msg.Ack()
+	})
+
+	t.Run("requires where to publish for queues that are received from", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		_, err := OpenTopic(ctx, dutyps.QueueConfig{PubSub: &dutyps.PubSubConfig{ProjectID: "p", Subscription: "orders-sub"}}, v1.TopicConfig{})
+		Expect(err).To(MatchError(ContainSubstring("project_id and topic are required")))
+
+		_, err = OpenTopic(ctx, dutyps.QueueConfig{RabbitMQ: &dutyps.RabbitConfig{Queue: "orders"}}, v1.TopicConfig{})
+		Expect(err).To(MatchError(ContainSubstring("exchange is required")))
+
+		_, err = OpenTopic(ctx, dutyps.QueueConfig{Kafka: &dutyps.KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "orders"}}, v1.TopicConfig{KafkaVersion: "latest"})
+		Expect(err).To(MatchError(ContainSubstring("invalid kafkaVersion latest")))
+	})
+}
+
+func TestSendMessages(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	queue := dutyps.QueueConfig{Memory: &dutyps.MemoryConfig{QueueName: "send-messages-test"}}
+
+	// memory subscriptions can only be opened once the topic exists
+	topic, err := OpenTopic(ctx, queue, v1.TopicConfig{})
+	Expect(err).To(BeNil())
+	defer topic.Shutdown(ctx)
+	sub, err := pubsub.OpenSubscription(ctx, "mem://send-messages-test")
+	Expect(err).To(BeNil())
+	defer sub.Shutdown(ctx)
+
+	Expect(SendMessages(ctx, queue, v1.TopicConfig{}, []byte(`{"a":"b"}`), map[string]string{"source": "test"}, 3)).To(Succeed())
+	for i := 0; i < 3; i++ {
+		msg, err := sub.Receive(ctx)
+		Expect(err).To(BeNil())
+		Expect(string(msg.Body)).To(Equal(`{"a":"b"}`))
+		Expect(msg.Metadata).To(Equal(map[string]string{"source": "test"}))
+		msg.Ack()
+	}
+
+	Expect(SendMessages(ctx, dutyps.QueueConfig{}, v1.TopicConfig{}, nil, nil, 1)).To(MatchError(ContainSubstring("no queue configuration provided")))
+}
@@ -0,0 +1,228 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	"github.com/samber/oops"
+	"gocloud.dev/pubsub"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// PublishMessage is the rendered message of a publish action
+type PublishMessage struct {
+	Body     string            `json:"body"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Rendered is a config templated with a message, only the field of the configured action is set
+type Rendered struct {
+	Pod     *corev1.Pod     `json:"pod,omitempty"`
+	Job     *batchv1.Job    `json:"job,omitempty"`
+	Exec    *v1.ExecAction  `json:"exec,omitempty"`
+	HTTP    *v1.HTTPAction  `json:"http,omitempty"`
+	Publish *PublishMessage `json:"publish,omitempty"`
+	SQL     *v1.SQLAction   `json:"sql,omitempty"`
+	Helm    *v1.HelmAction  `json:"helm,omitempty"`
+	// Git has the rendered manifests that are committed
+	Git       *v1.GitAction               `json:"git,omitempty"`
+	PodExec   *v1.PodExecAction           `json:"podExec,omitempty"`
+	Resources []unstructured.Unstructured `json:"resources,omitempty"`
+
+	// Decoded is the message body after base64 decoding
+	Decoded []byte `json:"-"`
+	// DecodeDuration is how long decoding the message took, as part of rendering it
+	DecodeDuration time.Duration `json:"-"`
+	Provenance     Provenance    `json:"-"`
+	Templater      Templater     `json:"-"`
+}
+
+// DecodeMessage returns the body of msg decoded from base64 if possible, and the template values of the message:
+// the fields of the body if it is JSON, or else the body as .body
+func DecodeMessage(msg *pubsub.Message) ([]byte, map[string]any) {
+	// Attempt to decode Base64
+	decoded, err := base64.StdEncoding.DecodeString(string(msg.Body))
+	if err != nil {
+		decoded = msg.Body
+	}
+
+	// Attempt to unmarshal to map
+	var data map[string]any
+	if err := json.Unmarshal(decoded, &data); err != nil || data == nil {
+		data = map[string]any{"body": string(decoded)}
+	}
+	data["_raw_body"] = string(msg.Body)
+	data["_id"] = msg.LoggableID
+	data["_metadata"] = msg.Metadata
+	return decoded, data
+}
+
+// Render decodes msg and templates the action of config with it, adding provenance and lifecycle metadata to
+// the objects that are created. Template functions that look up cluster state are only available with a lookup.
+func Render(ctx context.Context, config *v1.Config, msg *pubsub.Message, received time.Time, lookup *ClusterLookup) (r *Rendered, err error) {
+	_, decodeSpan := startSpan(ctx, "decode")
+	start := time.Now()
+	decoded, data := DecodeMessage(msg)
+	decodeDuration := time.Since(start)
+	decodeSpan.End()
+	ctx.Debugf("Received message:\n %+v", pretty(data))
+
+	ctx, span := startSpan(ctx, "template")
+	defer func() { endSpan(span, err) }()
+
+	provenance := NewProvenance(ctx, msg, received)
+	AddTemplateContext(ctx, data, provenance, config.Template)
+
+	templater, err := NewTemplater(ctx, config.Template, data, lookup)
+	if err != nil {
+		return nil, err
+	}
+	r = &Rendered{Decoded: decoded, DecodeDuration: decodeDuration, Provenance: provenance, Templater: templater}
+
+	switch {
+	case config.Pod != nil:
+		pod := config.Pod.DeepCopy()
+		if err := walkTemplate(templater, "pod", &pod); err != nil {
+			return nil, err
+		}
+		provenance.Apply(pod)
+		applyLifecycle(config, provenance, pod)
+		r.Pod = pod
+	case config.Job != nil:
+		job := config.Job.DeepCopy()
+		if err := walkTemplate(templater, "job", job); err != nil {
+			return nil, err
+		}
+		provenance.Apply(job)
+		provenance.ApplyTemplate(&job.Spec.Template, config.Mode)
+		applyLifecycle(config, provenance, job)
+		r.Job = job
+	case config.Exec != nil:
+		exec := config.Exec.DeepCopy()
+		if err := walkTemplate(templater, "exec", exec); err != nil {
+			return nil, err
+		}
+		r.Exec = exec
+	case config.HTTP != nil:
+		action := config.HTTP.DeepCopy()
+		if err := walkTemplate(templater, "http", action); err != nil {
+			return nil, err
+		}
+		r.HTTP = action
+	case config.Publish != nil:
+		out, err := renderPublish(config.Publish, templater, msg)
+		if err != nil {
+			return nil, oops.Wrapf(err, "error templating publish")
+		}
+		r.Publish = &PublishMessage{Body: string(out.Body), Metadata: out.Metadata}
+	case config.SQL != nil:
+		action := config.SQL.DeepCopy()
+		// queries are not templated, message values are passed as bind parameters so they can't inject SQL
+		queries := make([]string, len(action.Statements))
+		for i := range action.Statements {
+			queries[i], action.Statements[i].Query = action.Statements[i].Query, ""
+		}
+		if err := walkTemplate(templater, "sql", action); err != nil {
+			return nil, err
+		}
+		for i := range action.Statements {
+			action.Statements[i].Query = queries[i]
+		}
+		r.SQL = action
+	case config.Helm != nil:
+		action := config.Helm.DeepCopy()
+		if err := walkTemplate(templater, "helm", action); err != nil {
+			return nil, err
+		}
+		r.Helm = action
+	case config.Git != nil:
+		action := config.Git.DeepCopy()
+		// manifests are templated when rendered
+		resources := action.ResourcesAction
+		action.ResourcesAction = v1.ResourcesAction{}
+		if err := walkTemplate(templater, "git", action); err != nil {
+			return nil, err
+		}
+		objects, err := renderResources(&resources, templater)
+		if err != nil {
+			return nil, oops.Wrapf(err, "error rendering manifests for %s", action)
+		}
+		action.Manifests = objects
+		r.Git = action
+	case config.PodExec != nil:
+		action := config.PodExec.DeepCopy()
+		if err := walkTemplate(templater, "podExec", action); err != nil {
+			return nil, err
+		}
+		r.PodExec = action
+	case config.Resources != nil:
+		objects, err := renderResources(config.Resources, templater)
+		if err != nil {
+			return nil, oops.Wrapf(err, "error templating resources")
+		}
+		for i := range objects {
+			provenance.Apply(&objects[i])
+			applyLifecycle(config, provenance, &objects[i])
+		}
+		r.Resources = objects
+	default:
+		return nil, errNoAction
+	}
+	return r, nil
+}
+
+var errNoAction = fmt.Errorf("Invalid config, must specify one of pod, job, exec, http, publish, sql, helm, git, podExec or resources")
+
+// walkTemplate templates obj, and on failure returns an error with the path of the field that failed,
+// e.g. job.spec.template.spec.containers[0].args[1]
+func walkTemplate(templater Templater, name string, obj any) error {
+	original, _ := json.Marshal(obj)
+	if err := templater.Walk(obj); err != nil {
+		var doc any
+		if json.Unmarshal(original, &doc) == nil {
+			if path := failingField(templater, doc, name); path != "" {
+				return oops.Wrapf(err, "error templating %s", path)
+			}
+		}
+		return oops.Wrapf(err, "error templating %s", name)
+	}
+	return nil
+}
+
+// failingField returns the path of the first field of doc that fails to template
+func failingField(templater Templater, doc any, path string) string {
+	switch v := doc.(type) {
+	case string:
+		if _, err := templater.Template(v); err != nil {
+			return path
+		}
+	case []any:
+		for i, item := range v {
+			if p := failingField(templater, item, fmt.Sprintf("%s[%d]", path, i)); p != "" {
+				return p
+			}
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if _, err := templater.Template(k); err != nil {
+				return path + "." + k
+			}
+			if p := failingField(templater, v[k], path+"."+k); p != "" {
+				return p
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	. "github.com/onsi/gomega"
+	"gocloud.dev/pubsub"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	RegisterTestingT(t)
+
+	decoded, data := DecodeMessage(&pubsub.Message{LoggableID: "1", Body: []byte(base64.StdEncoding.EncodeToString([]byte(`{"a":"b"}`)))})
+	Expect(string(decoded)).To(Equal(`{"a":"b"}`))
+	Expect(data).To(HaveKeyWithValue("a", "b"))
+	Expect(data).To(HaveKeyWithValue("_id", "1"))
+
+	_, data = DecodeMessage(&pubsub.Message{Body: []byte("plain text")})
+	Expect(data).To(HaveKeyWithValue("body", "plain text"))
+}
+
+func TestRender(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	msg := &pubsub.Message{LoggableID: "msg-1", Body: []byte(`{"name":"sync"}`)}
+	job := func(args ...string) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "job-{{.name}}", Namespace: "default"},
+			Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "main", Image: "busybox", Args: args}},
+			}}},
+		}
+	}
+
+	t.Run("templates the action and adds provenance", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		rendered, err := Render(ctx, &v1.Config{Job: job("{{.name}}")}, msg, time.Now(), nil)
+		Expect(err).To(BeNil())
+		Expect(rendered.Job.Name).To(Equal("job-sync"))
+		Expect(rendered.Job.Spec.Template.Spec.Containers[0].Args).To(Equal([]string{"sync"}))
+		Expect(rendered.Job.Labels).To(HaveKeyWithValue(LabelMessageID, "msg-1"))
+		Expect(rendered.Pod).To(BeNil())
+	})
+
+	t.Run("applies the same Job for every message", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		// the pod template of a Job is immutable, so a second apply only succeeds if it is unchanged
+		config := &v1.Config{Job: job("{{.name}}"), Mode: v1.ModeApply}
+		apply := func(id string) *unstructured.Unstructured {
+			rendered, err := Render(ctx, config, &pubsub.Message{LoggableID: id, Body: msg.Body}, time.Now(), nil)
+			Expect(err).To(BeNil())
+			u, err := toUnstructured(rendered.Job, batchv1.SchemeGroupVersion.WithKind("Job"))
+			Expect(err).To(BeNil())
+			return u
+		}
+
+		first, second := apply("msg-1"), apply("msg-2")
+		Expect(second.GetName()).To(Equal(first.GetName()))
+		Expect(second.GetLabels()).To(HaveKeyWithValue(LabelMessageID, "msg-2"))
+		Expect(second.Object["spec"]).To(Equal(first.Object["spec"]))
+	})
+
+	t.Run("only templates the args of SQL statements", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		query := "INSERT INTO events (name, note) VALUES ($1, '{{.name}}')"
+		config := &v1.Config{SQL: &v1.SQLAction{Statements: []v1.SQLStatement{{Query: query, Args: []string{"{{.name}}"}}}}}
+		rendered, err := Render(ctx, config, msg, time.Now(), nil)
+		Expect(err).To(BeNil())
+		Expect(rendered.SQL.Statements[0].Query).To(Equal(query))
+		Expect(rendered.SQL.Statements[0].Args).To(Equal([]string{"sync"}))
+		Expect(config.SQL.Statements[0].Args).To(Equal([]string{"{{.name}}"}))
+	})
+
+	t.Run("reports the failing field", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		_, err := Render(ctx, &v1.Config{Job: job("ok", "{{ .name | nosuchfunc }}")}, msg, time.Now(), nil)
+		Expect(err).To(MatchError(ContainSubstring("error templating job.spec.template.spec.containers[0].args[1]")))
+
+		_, err = Render(ctx, &v1.Config{Job: job("$(name"), Template: &v1.TemplateConfig{Engine: v1.TemplateEngineCEL}}, msg, time.Now(), nil)
+		Expect(err).To(MatchError(ContainSubstring("error templating job.spec.template.spec.containers[0].args[0]")))
+	})
+
+	t.Run("renders git manifests", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		rendered, err := Render(ctx, &v1.Config{Git: &v1.GitAction{
+			Path:            "{{.name}}.yaml",
+			ResourcesAction: v1.ResourcesAction{Template: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: '{{.name}}'\n"},
+		}}, msg, time.Now(), nil)
+		Expect(err).To(BeNil())
+		Expect(rendered.Git.Path).To(Equal("sync.yaml"))
+		Expect(rendered.Git.Template).To(BeEmpty())
+		Expect(rendered.Git.Manifests).To(HaveLen(1))
+		Expect(rendered.Git.Manifests[0].GetName()).To(Equal("sync"))
+	})
+
+	t.Run("embeds a JSON body in a resource", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		body := "{\n  \"id\": \"42\",\n  \"items\": [\"a\", \"b\"]\n}"
+		rendered, err := Render(ctx, &v1.Config{Resources: &v1.ResourcesAction{Template: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: "payload-{{.id}}"
+  namespace: default
+stringData:
+  body: {{ ._raw_body | toJSON }}
+`}}, &pubsub.Message{LoggableID: "msg-2", Body: []byte(body)}, time.Now(), nil)
+		Expect(err).To(BeNil())
+		Expect(rendered.Resources).To(HaveLen(1))
+		Expect(rendered.Resources[0].GetName()).To(Equal("payload-42"))
+		Expect(rendered.Resources[0].Object["stringData"]).To(HaveKeyWithValue("body", body))
+	})
+
+	t.Run("requires an action", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		_, err := Render(ctx, &v1.Config{}, msg, time.Now(), nil)
+		Expect(err).To(MatchError(errNoAction))
+	})
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"fmt"
+	"maps"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	"github.com/samber/oops"
+	"gocloud.dev/pubsub"
+	"golang.org/x/time/rate"
+)
+
+// Targets of a replay
+const (
+	ReplayToQueue  = "queue"
+	ReplayToAction = "action"
+)
+
+// ReplayOptions controls how ReplayMessages feeds failed messages back
+type ReplayOptions struct {
+	// Target is either ReplayToQueue to send the messages to the queue of the config, or ReplayToAction
+	// to run the action of the config for each message without a queue
+	Target string
+	// Since only replays messages that failed after it
+	Since time.Time
+	// Rate is the maximum number of messages replayed each second, 0 is unlimited
+	Rate float64
+	// Destination is the GCP Pub/Sub topic or RabbitMQ exchange that feeds the queue of the config, for
+	// ReplayToQueue
+	Destination v1.TopicConfig
+}
+
+// ReplayMessages feeds the captured failed messages of a trigger back through config, oldest first, e.g. after
+// fixing a template. Messages are removed from the store once they have been sent to the queue, or the action
+// has succeeded. It returns the number of messages replayed and the number whose action failed again.
+func ReplayMessages(ctx context.Context, store *CaptureStore, config *v1.Config, trigger string, opts ReplayOptions) (replayed int, failed int, err error) {
+	messages, err := store.List(trigger, opts.Since)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(messages) == 0 {
+		return 0, 0, nil
+	}
+
+	limit := rate.Inf
+	if opts.Rate > 0 {
+		limit = rate.Limit(opts.Rate)
+	}
+	limiter := rate.NewLimiter(limit, 1)
+
+	switch opts.Target {
+	case ReplayToQueue:
+		topic, err := OpenTopic(ctx, config.QueueConfig, opts.Destination)
+		if err != nil {
+			return 0, 0, oops.Wrapf(err, "error opening %s", config.GetQueue())
+		}
+		defer func() {
+			if err := topic.Shutdown(ctx); err != nil {
+				ctx.Errorf("Error closing %s: %v", config.GetQueue(), err)
+			}
+		}()
+
+		for _, m := range messages {
+			if err := limiter.Wait(ctx); err != nil {
+				return replayed, failed, err
+			}
+			if err := topic.Send(ctx, &pubsub.Message{Body: m.Body, Metadata: maps.Clone(m.Metadata)}); err != nil {
+				return replayed, failed, oops.Wrapf(err, "error sending %s to %s", m.MessageID, config.GetQueue())
+			}
+			ctx.Infof("Replayed %s to %s", m.MessageID, config.GetQueue())
+			replayed++
+			if err := store.Remove(m); err != nil {
+				ctx.Errorf("Error removing replayed message %s: %v", m.MessageID, err)
+			}
+		}
+
+	case ReplayToAction:
+		if config.GetDestination() == nil {
+			return 0, 0, errNoAction
+		}
+		if _, err := templateDelims(config.Template); err != nil {
+			return 0, 0, oops.Wrapf(err, "Invalid config")
+		}
+		client, topic, closeTopic, err := openAction(ctx, config, false)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer closeTopic()
+
+		for _, m := range messages {
+			if err := limiter.Wait(ctx); err != nil {
+				return replayed, failed, err
+			}
+			msg := &pubsub.Message{LoggableID: m.MessageID, Body: m.Body, Metadata: maps.Clone(m.Metadata)}
+			if msg.Metadata == nil {
+				msg.Metadata = map[string]string{}
+			}
+			replayed++
+			result := processMessage(ctx, client, config, topic, false, 0, msg)
+			if result.Error != "" {
+				ctx.Errorf("Replay of %s failed: %s", m.MessageID, result.Error)
+				failed++
+				continue
+			}
+			ctx.Infof("Replayed %s: %s", m.MessageID, result.Created)
+			if err := store.Remove(m); err != nil {
+				ctx.Errorf("Error removing replayed message %s: %v", m.MessageID, err)
+			}
+		}
+
+	default:
+		return 0, 0, fmt.Errorf("unknown replay target %q, must be %s or %s", opts.Target, ReplayToQueue, ReplayToAction)
+	}
+	return replayed, failed, nil
+}
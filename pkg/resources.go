@@ -0,0 +1,199 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	dutyKubernetes "github.com/flanksource/duty/kubernetes"
+	"github.com/samber/oops"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// installOrder is the order in which kinds are created, any kind not listed is created
+// after the listed kinds, in the order it was specified
+var installOrder = []string{
+	"Namespace",
+	"ServiceAccount",
+	"Secret",
+	"ConfigMap",
+	"PersistentVolumeClaim",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"Pod",
+	"Deployment",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+}
+
+func installPriority(kind string) int {
+	for i, k := range installOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(installOrder)
+}
+
+// sortByInstallOrder sorts objects so that dependencies are created before the objects that use them
+func sortByInstallOrder(objects []unstructured.Unstructured) {
+	sort.SliceStable(objects, func(i, j int) bool {
+		return installPriority(objects[i].GetKind()) < installPriority(objects[j].GetKind())
+	})
+}
+
+// renderResources templates the manifests and the multi-document template of the action
+func renderResources(action *v1.ResourcesAction, templater Templater) ([]unstructured.Unstructured, error) {
+	action = action.DeepCopy()
+	if err := templater.Walk(&action.Manifests); err != nil {
+		return nil, oops.Wrapf(err, "error templating manifests")
+	}
+
+	objects := action.Manifests
+	if action.Template != "" {
+		rendered, err := templater.Template(action.Template)
+		if err != nil {
+			return nil, oops.Wrapf(err, "error templating resources")
+		}
+		parsed, err := dutyKubernetes.GetUnstructuredObjects([]byte(rendered))
+		if err != nil {
+			return nil, oops.Wrapf(err, "error parsing rendered resources")
+		}
+		objects = append(objects, parsed...)
+	}
+
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("resources rendered no objects")
+	}
+	sortByInstallOrder(objects)
+	return objects, nil
+}
+
+type createdResource struct {
+	client dynamic.ResourceInterface
+	object *unstructured.Unstructured
+}
+
+func resourceClient(ctx context.Context, client *dutyKubernetes.Client, obj unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	rc, err := client.GetClientByGroupVersionKind(ctx, gvk.Group, gvk.Version, gvk.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if obj.GetNamespace() == "" {
+		return rc, nil
+	}
+	return rc.Namespace(obj.GetNamespace()), nil
+}
+
+// createResources creates each object in order, and then makes the last object the owner of the objects this
+// call created before it in the same namespace. On any failure all objects created so far are deleted, objects
+// that already existed and were kept due to the onConflict policy, or that were applied, are left as is and are
+// never owned. It returns the last (owning) object, and whether it already existed and was kept.
+func createResources(ctx context.Context, client *dutyKubernetes.Client, objects []unstructured.Unstructured, config *v1.Config) (*unstructured.Unstructured, bool, error) {
+	return createObjects(ctx, objects, config, resourceCreator{
+		client: func(obj unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+			return resourceClient(ctx, client, obj)
+		},
+		apply: func(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+			return applyObject(ctx, client, obj, obj.GroupVersionKind())
+		},
+	})
+}
+
+// resourceCreator returns the client of an object, and server-side applies objects in apply mode
+type resourceCreator struct {
+	client func(obj unstructured.Unstructured) (dynamic.ResourceInterface, error)
+	apply  func(obj *unstructured.Unstructured) (*unstructured.Unstructured, error)
+}
+
+func createObjects(ctx context.Context, objects []unstructured.Unstructured, config *v1.Config, creator resourceCreator) (*unstructured.Unstructured, bool, error) {
+	var created []createdResource
+	var owner *unstructured.Unstructured
+	var skipped bool
+	for i := range objects {
+		obj := objects[i]
+		rc, err := creator.client(obj)
+		if err != nil {
+			rollbackResources(ctx, created)
+			return &obj, false, oops.Wrapf(err, "error getting client for %s", obj.GetKind())
+		}
+
+		var result *unstructured.Unstructured
+		var isNew bool
+		if config.Mode == v1.ModeApply {
+			result, err = creator.apply(&obj)
+		} else {
+			result, isNew, err = createWithPolicy(ctx, dynamicObjectClient{rc}, &obj, config.OnConflict)
+		}
+		if err != nil {
+			rollbackResources(ctx, created)
+			return &obj, false, err
+		}
+		if isNew {
+			ctx.Debugf("Created %s %s/%s", result.GetKind(), result.GetNamespace(), result.GetName())
+			created = append(created, createdResource{client: rc, object: result})
+		}
+		owner = result
+		skipped = !isNew && config.Mode != v1.ModeApply
+	}
+
+	// only objects created by this message are owned, so that deleting the owner never deletes objects that
+	// already existed or are shared with other messages
+	for _, dep := range created {
+		if dep.object == owner || dep.object.GetNamespace() != owner.GetNamespace() || dep.object.GetNamespace() == "" {
+			continue
+		}
+		patch, err := ownerPatch(dep.object, owner)
+		if err != nil || patch == nil {
+			continue
+		}
+		if _, err := dep.client.Patch(ctx, dep.object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			rollbackResources(ctx, created)
+			return owner, false, oops.Wrapf(err, "error setting owner of %s/%s", dep.object.GetKind(), dep.object.GetName())
+		}
+	}
+
+	return owner, skipped, nil
+}
+
+// ownerPatch returns a merge patch that adds owner to the existing owner references of obj,
+// or nil if owner is already referenced. owner is only made the controller if obj does not have one.
+func ownerPatch(obj, owner *unstructured.Unstructured) ([]byte, error) {
+	ref := *metav1.NewControllerRef(owner, owner.GroupVersionKind())
+	refs := obj.GetOwnerReferences()
+	for _, r := range refs {
+		if r.UID == owner.GetUID() {
+			return nil, nil
+		}
+		if r.Controller != nil && *r.Controller {
+			ref.Controller = nil
+		}
+	}
+
+	return json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"ownerReferences": append(refs, ref),
+		},
+	})
+}
+
+// rollbackResources deletes created objects in the reverse order of creation
+func rollbackResources(ctx context.Context, created []createdResource) {
+	propagation := metav1.DeletePropagationBackground
+	for i := len(created) - 1; i >= 0; i-- {
+		obj := created[i].object
+		if err := created[i].client.Delete(ctx, obj.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil {
+			ctx.Errorf("Error rolling back %s %s/%s: %v", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+		} else {
+			ctx.Warnf("Rolled back %s %s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	"github.com/flanksource/gomplate/v3"
+	. "github.com/onsi/gomega"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestRenderResources(t *testing.T) {
+	RegisterTestingT(t)
+
+	templater := gomplate.StructTemplater{
+		Context:   context.New().Context,
+		Values:    map[string]any{"a": "first"},
+		DelimSets: []gomplate.Delims{{Left: "{{", Right: "}}"}},
+	}
+
+	t.Run("orders dependencies before workloads", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		action := &v1.ResourcesAction{
+			Manifests: []unstructured.Unstructured{
+				{Object: map[string]any{
+					"apiVersion": "batch/v1",
+					"kind":       "Job",
+					"metadata":   map[string]any{"name": "batch-{{.a}}", "namespace": "default"},
+				}},
+			},
+			Template: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: payload-{{.a}}
+  namespace: default
+stringData:
+  body: "{{.a}}"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config-{{.a}}
+  namespace: default
+`,
+		}
+
+		objects, err := renderResources(action, templater)
+		Expect(err).To(BeNil())
+		Expect(objects).To(HaveLen(3))
+		Expect(objects[0].GetKind()).To(Equal("Secret"))
+		Expect(objects[0].GetName()).To(Equal("payload-first"))
+		Expect(objects[1].GetKind()).To(Equal("ConfigMap"))
+		Expect(objects[2].GetKind()).To(Equal("Job"))
+		Expect(objects[2].GetName()).To(Equal("batch-first"))
+
+		Expect(action.Manifests[0].GetName()).To(Equal("batch-{{.a}}"), "action should not be mutated")
+	})
+
+	t.Run("fails when nothing is rendered", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		_, err := renderResources(&v1.ResourcesAction{Template: "{{ if false }}kind: Pod{{ end }}"}, templater)
+		Expect(err).ToNot(BeNil())
+	})
+}
+
+func manifest(apiVersion, kind, name string) unstructured.Unstructured {
+	return unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   map[string]any{"name": name, "namespace": "default", "uid": name + "-uid"},
+	}}
+}
+
+// fakeResourceCreator creates objects with a fake dynamic client, the resource of a kind is its lowercase plural
+func fakeResourceCreator(dyn *dynamicfake.FakeDynamicClient) resourceCreator {
+	return resourceCreator{client: func(obj unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+		gvk := obj.GroupVersionKind()
+		gvr := schema.GroupVersionResource{Group: gvk.Group, Version: gvk.Version, Resource: strings.ToLower(gvk.Kind) + "s"}
+		return dyn.Resource(gvr).Namespace(obj.GetNamespace()), nil
+	}}
+}
+
+func TestCreateResources(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	secrets := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+	t.Run("owns only the objects it created", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		shared := manifest("v1", "ConfigMap", "shared")
+		dyn := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), shared.DeepCopy())
+		objects := []unstructured.Unstructured{manifest("v1", "Secret", "payload"), shared, manifest("batch/v1", "Job", "process")}
+
+		owner, skipped, err := createObjects(ctx, objects, &v1.Config{OnConflict: v1.ConflictSkip}, fakeResourceCreator(dyn))
+		Expect(err).To(BeNil())
+		Expect(owner.GetName()).To(Equal("process"))
+		Expect(skipped).To(BeFalse())
+
+		payload, err := dyn.Resource(secrets).Namespace("default").Get(ctx, "payload", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(payload.GetOwnerReferences()).To(HaveLen(1))
+		Expect(payload.GetOwnerReferences()[0].UID).To(Equal(types.UID("process-uid")))
+		Expect(*payload.GetOwnerReferences()[0].Controller).To(BeTrue())
+
+		existing, err := dyn.Resource(configMaps).Namespace("default").Get(ctx, "shared", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(existing.GetOwnerReferences()).To(BeEmpty(), "objects kept by onConflict: skip are not owned")
+	})
+
+	t.Run("reports a skipped owner", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		job := manifest("batch/v1", "Job", "process")
+		dyn := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), job.DeepCopy())
+		objects := []unstructured.Unstructured{manifest("v1", "Secret", "payload"), job}
+
+		owner, skipped, err := createObjects(ctx, objects, &v1.Config{OnConflict: v1.ConflictSkip}, fakeResourceCreator(dyn))
+		Expect(err).To(BeNil())
+		Expect(owner.GetName()).To(Equal("process"))
+		Expect(skipped).To(BeTrue())
+	})
+
+	t.Run("rolls back the objects it created", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		shared := manifest("v1", "ConfigMap", "shared")
+		dyn := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), shared.DeepCopy())
+		objects := []unstructured.Unstructured{manifest("v1", "Secret", "payload"), shared, manifest("batch/v1", "Job", "process")}
+
+		_, _, err := createObjects(ctx, objects, &v1.Config{OnConflict: v1.ConflictFail}, fakeResourceCreator(dyn))
+		Expect(err).ToNot(BeNil())
+
+		_, err = dyn.Resource(secrets).Namespace("default").Get(ctx, "payload", metav1.GetOptions{})
+		Expect(kerrors.IsNotFound(err)).To(BeTrue(), "created objects are deleted")
+		_, err = dyn.Resource(configMaps).Namespace("default").Get(ctx, "shared", metav1.GetOptions{})
+		Expect(err).To(BeNil(), "objects that already existed are kept")
+	})
+}
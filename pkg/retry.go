@@ -57,6 +57,26 @@ func (rc *RetryCache) GetBackoff(ctx context.Context, messageID string, retry *v
 	return &baseDelay
 }
 
+// Attempt returns the attempt number of the next delivery of a message, starting at 1
+func (rc *RetryCache) Attempt(ctx context.Context, messageID string) int {
+	item, _ := rc.items.Get(ctx, messageID)
+	if item == nil {
+		return 1
+	}
+	return item.Count + 1
+}
+
+// RecordAttempt records a failed attempt of a message that will be redelivered
+func (rc *RetryCache) RecordAttempt(ctx context.Context, messageID string) {
+	item, _ := rc.items.Get(ctx, messageID)
+	if item == nil {
+		item = &RetryItem{}
+	}
+	item.Count++
+	item.LastAttempt = time.Now()
+	rc.items.Set(ctx, messageID, item)
+}
+
 func (rc *RetryCache) Remove(ctx context.Context, messageID string) {
 	rc.items.Delete(ctx, messageID)
 }
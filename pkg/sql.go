@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	gocontext "context"
+	"database/sql"
+	"fmt"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/connection"
+	"github.com/flanksource/duty/context"
+	"github.com/samber/oops"
+
+	_ "github.com/glebarez/go-sqlite"
+)
+
+const sqlTypeSQLite = "sqlite"
+
+// openSQL hydrates and opens conn, adding sqlite to the database types supported by duty
+func openSQL(ctx context.Context, conn connection.SQLConnection) (*sql.DB, error) {
+	if err := conn.HydrateConnection(ctx); err != nil {
+		return nil, err
+	}
+	if conn.Type == sqlTypeSQLite {
+		return sql.Open(sqlTypeSQLite, conn.URL.ValueStatic)
+	}
+	return conn.Client(ctx)
+}
+
+type sqlExecer interface {
+	ExecContext(ctx gocontext.Context, query string, args ...any) (sql.Result, error)
+}
+
+// runSQL executes the statements of an already templated action, returning the total number of rows affected
+func runSQL(ctx context.Context, action v1.SQLAction) (int64, error) {
+	db, err := openSQL(ctx, action.SQLConnection)
+	if err != nil {
+		return 0, oops.Wrapf(err, "error opening %s", action)
+	}
+	defer db.Close()
+
+	if !action.Transaction {
+		return execStatements(ctx, db, action.Statements)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, oops.Wrapf(err, "error starting transaction")
+	}
+	rows, err := execStatements(ctx, tx, action.Statements)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			ctx.Errorf("Error rolling back transaction: %v", rollbackErr)
+		}
+		return 0, err
+	}
+	return rows, tx.Commit()
+}
+
+func execStatements(ctx context.Context, db sqlExecer, statements []v1.SQLStatement) (int64, error) {
+	var total int64
+	for i, statement := range statements {
+		args := make([]any, len(statement.Args))
+		for j, arg := range statement.Args {
+			args[j] = arg
+		}
+
+		result, err := db.ExecContext(ctx, statement.Query, args...)
+		if err != nil {
+			return total, oops.Wrapf(err, "error executing statement %d", i+1)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return total, oops.Wrapf(err, "error getting rows affected by statement %d", i+1)
+		}
+		if statement.RowsAffected != nil && rows != *statement.RowsAffected {
+			return total, fmt.Errorf("statement %d affected %d rows, expected %d", i+1, rows, *statement.RowsAffected)
+		}
+		ctx.Tracef("statement %d affected %d rows", i+1, rows)
+		total += rows
+	}
+	return total, nil
+}
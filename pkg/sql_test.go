@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/connection"
+	"github.com/flanksource/duty/context"
+	"github.com/flanksource/duty/types"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+)
+
+func TestRunSQL(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	path := filepath.Join(t.TempDir(), "test.db")
+	conn := connection.SQLConnection{Type: "sqlite", URL: types.EnvVar{ValueStatic: path}}
+
+	db, err := sql.Open("sqlite", path)
+	Expect(err).To(BeNil())
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE orders (id TEXT PRIMARY KEY, status TEXT)")
+	Expect(err).To(BeNil())
+
+	count := func() int {
+		var n int
+		Expect(db.QueryRow("SELECT count(*) FROM orders").Scan(&n)).To(Succeed())
+		return n
+	}
+
+	t.Run("binds args", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		rows, err := runSQL(ctx, v1.SQLAction{
+			SQLConnection: conn,
+			Statements: []v1.SQLStatement{
+				{Query: "INSERT INTO orders (id, status) VALUES (?, ?)", Args: []string{"1", "new"}},
+				{Query: "INSERT INTO orders (id, status) VALUES (?, ?)", Args: []string{"2", "new"}},
+			},
+		})
+		Expect(err).To(BeNil())
+		Expect(rows).To(Equal(int64(2)))
+		Expect(count()).To(Equal(2))
+	})
+
+	t.Run("rolls back the transaction when the row count does not match", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		_, err := runSQL(ctx, v1.SQLAction{
+			SQLConnection: conn,
+			Transaction:   true,
+			Statements: []v1.SQLStatement{
+				{Query: "INSERT INTO orders (id, status) VALUES (?, ?)", Args: []string{"3", "new"}},
+				{Query: "UPDATE orders SET status = ? WHERE id = ?", Args: []string{"done", "4"}, RowsAffected: lo.ToPtr(int64(1))},
+			},
+		})
+		Expect(err).To(MatchError(ContainSubstring("statement 2 affected 0 rows, expected 1")))
+		Expect(count()).To(Equal(2))
+	})
+}
@@ -0,0 +1,236 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	"github.com/flanksource/gomplate/v3"
+	"github.com/samber/lo"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Templater renders the strings of an action with the message, gomplate.StructTemplater is used for go templates
+type Templater interface {
+	Template(val string) (string, error)
+	Walk(object any) error
+}
+
+// templateDelims returns the delimiters of the configured engine
+func templateDelims(config *v1.TemplateConfig) (gomplate.Delims, error) {
+	if config == nil {
+		return gomplate.Delims{Left: "{{", Right: "}}"}, nil
+	}
+	if len(config.Delims) > 0 {
+		if len(config.Delims) != 2 || config.Delims[0] == "" || config.Delims[1] == "" {
+			return gomplate.Delims{}, fmt.Errorf("template.delims must be a left and right delimiter, got %q", config.Delims)
+		}
+		return gomplate.Delims{Left: config.Delims[0], Right: config.Delims[1]}, nil
+	}
+	switch config.Engine {
+	case "", v1.TemplateEngineGo:
+		return gomplate.Delims{Left: "{{", Right: "}}"}, nil
+	case v1.TemplateEngineCEL, v1.TemplateEngineJavascript:
+		return gomplate.Delims{Left: "$(", Right: ")"}, nil
+	default:
+		return gomplate.Delims{}, fmt.Errorf("unknown template engine %q, must be one of gotemplate, cel or javascript", config.Engine)
+	}
+}
+
+// templateEnv returns the allow-listed environment variables, unset variables are empty
+func templateEnv(config *v1.TemplateConfig) map[string]any {
+	env := map[string]any{}
+	if config != nil {
+		for _, name := range config.Env {
+			env[name] = os.Getenv(name)
+		}
+	}
+	return env
+}
+
+// AddTemplateContext adds the .trigger, .received, .attempt, .uid and .env fields to the values of a message, and
+// the same fields under ._batch. Fields of the message with the same names are kept with a warning, the added
+// values are then only available under ._batch.
+func AddTemplateContext(ctx context.Context, values map[string]any, provenance Provenance, config *v1.TemplateConfig) {
+	batch := provenance.TemplateValues()
+	batch["env"] = templateEnv(config)
+	values["_batch"] = batch
+	for k, v := range batch {
+		if _, ok := values[k]; ok {
+			ctx.Warnf("Message field %s is used instead of the template value, which is available as ._batch.%s", k, k)
+			continue
+		}
+		values[k] = v
+	}
+}
+
+// NewTemplater returns a templater for the engine and delimiters of config, with the functions of lookup if not nil
+func NewTemplater(ctx context.Context, config *v1.TemplateConfig, values map[string]any, lookup *ClusterLookup) (Templater, error) {
+	delims, err := templateDelims(config)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || config.Engine == "" || config.Engine == v1.TemplateEngineGo {
+		templater := gomplate.StructTemplater{
+			Context:        ctx.Context,
+			Values:         values,
+			DelimSets:      []gomplate.Delims{delims},
+			ValueFunctions: true,
+		}
+		if lookup != nil {
+			templater.Funcs = lookup.goFuncs()
+		}
+		return templater, nil
+	}
+	return exprTemplater{ctx: ctx, engine: config.Engine, delims: delims, values: values, lookup: lookup}, nil
+}
+
+// exprTemplater replaces each delimited CEL or javascript expression in a string with its result
+type exprTemplater struct {
+	ctx    context.Context
+	engine v1.TemplateEngine
+	delims gomplate.Delims
+	values map[string]any
+	lookup *ClusterLookup
+}
+
+func (t exprTemplater) Template(val string) (string, error) {
+	var out strings.Builder
+	for {
+		start := strings.Index(val, t.delims.Left)
+		if start < 0 {
+			out.WriteString(val)
+			return out.String(), nil
+		}
+		out.WriteString(val[:start])
+		rest := val[start+len(t.delims.Left):]
+		end := closingDelim(rest, t.delims.Right)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated expression, missing %q: %s", t.delims.Right, val[start:])
+		}
+		result, err := t.eval(rest[:end])
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(result)
+		val = rest[end+len(t.delims.Right):]
+	}
+}
+
+func (t exprTemplater) eval(expr string) (string, error) {
+	if t.engine == v1.TemplateEngineJavascript {
+		env := t.values
+		if t.lookup != nil {
+			env = lo.Assign(t.values, t.lookup.jsFuncs())
+		}
+		return gomplate.RunTemplateContext(t.ctx.Context, env, gomplate.Template{Javascript: expr})
+	}
+
+	template := gomplate.Template{Expression: expr}
+	if t.lookup != nil {
+		template.CelEnvs = t.lookup.celEnvs()
+	}
+	result, err := gomplate.RunExpressionContext(t.ctx.Context, t.values, template)
+	if err != nil {
+		return "", err
+	}
+	switch v := result.(type) {
+	case nil, structpb.NullValue:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprintf("%v", v), nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// closingDelim returns the index of right in s, skipping quoted strings and, when right is a closing
+// bracket, nested brackets so that e.g. $(size(x)) is a single expression
+func closingDelim(s, right string) int {
+	open := map[string]byte{")": '(', "]": '[', "}": '{'}[right]
+	var quote byte
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case depth == 0 && strings.HasPrefix(s[i:], right):
+			return i
+		case open != 0 && c == open:
+			depth++
+		case open != 0 && c == right[0]:
+			depth--
+		}
+	}
+	return -1
+}
+
+// Walk templates every string of object, which is round-tripped through JSON
+func (t exprTemplater) Walk(object any) error {
+	b, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	var doc any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	if doc, err = t.walk(doc); err != nil {
+		return err
+	}
+	if b, err = json.Marshal(doc); err != nil {
+		return err
+	}
+
+	// reset the object so that map keys that were templated are not merged with the originals
+	v := reflect.ValueOf(object).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	return json.Unmarshal(b, object)
+}
+
+func (t exprTemplater) walk(doc any) (any, error) {
+	switch v := doc.(type) {
+	case string:
+		return t.Template(v)
+	case []any:
+		for i := range v {
+			item, err := t.walk(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = item
+		}
+		return v, nil
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			key, err := t.Template(k)
+			if err != nil {
+				return nil, err
+			}
+			if out[key], err = t.walk(item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	default:
+		return doc, nil
+	}
+}
@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"testing"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTemplater(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.New()
+	values := map[string]any{"id": "abc", "items": []any{"a", "b"}, "params": map[string]any{"env": "dev"}}
+
+	render := func(config *v1.TemplateConfig, val string) (string, error) {
+		templater, err := NewTemplater(ctx, config, values, nil)
+		Expect(err).To(BeNil())
+		return templater.Template(val)
+	}
+
+	t.Run("defaults to go templates", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		out, err := render(nil, "job-{{.id}}")
+		Expect(err).To(BeNil())
+		Expect(out).To(Equal("job-abc"))
+	})
+
+	t.Run("passes other placeholders through with custom delims", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		out, err := render(&v1.TemplateConfig{Delims: []string{"[[", "]]"}}, "[[.id]] {{workflow.name}}")
+		Expect(err).To(BeNil())
+		Expect(out).To(Equal("abc {{workflow.name}}"))
+	})
+
+	t.Run("evaluates cel expressions", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		out, err := render(&v1.TemplateConfig{Engine: v1.TemplateEngineCEL}, "job-$(id + '-' + params.env) $(size(items)) {{workflow.name}}")
+		Expect(err).To(BeNil())
+		Expect(out).To(Equal("job-abc-dev 2 {{workflow.name}}"))
+
+		out, err = render(&v1.TemplateConfig{Engine: v1.TemplateEngineCEL}, "$(items)")
+		Expect(err).To(BeNil())
+		Expect(out).To(Equal(`["a","b"]`))
+
+		_, err = render(&v1.TemplateConfig{Engine: v1.TemplateEngineCEL}, "$(id")
+		Expect(err).To(MatchError(ContainSubstring("unterminated")))
+	})
+
+	t.Run("evaluates javascript", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		out, err := render(&v1.TemplateConfig{Engine: v1.TemplateEngineJavascript}, "job-$(id.toUpperCase())")
+		Expect(err).To(BeNil())
+		Expect(out).To(Equal("job-ABC"))
+	})
+
+	t.Run("walks objects with expressions", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		templater, err := NewTemplater(ctx, &v1.TemplateConfig{Engine: v1.TemplateEngineCEL}, values, nil)
+		Expect(err).To(BeNil())
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "job-$(id)", Labels: map[string]string{"$(params.env)": "true"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Args: []string{"$(items[1])", "{{inputs.parameters.x}}"}}}},
+		}
+		Expect(templater.Walk(&pod)).To(Succeed())
+		Expect(pod.Name).To(Equal("job-abc"))
+		Expect(pod.Labels).To(Equal(map[string]string{"dev": "true"}))
+		Expect(pod.Spec.Containers[0].Args).To(Equal([]string{"b", "{{inputs.parameters.x}}"}))
+	})
+
+	t.Run("rejects invalid config", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		_, err := NewTemplater(ctx, &v1.TemplateConfig{Delims: []string{"[["}}, values, nil)
+		Expect(err).ToNot(BeNil())
+		_, err = NewTemplater(ctx, &v1.TemplateConfig{Engine: "jinja"}, values, nil)
+		Expect(err).ToNot(BeNil())
+	})
+}
@@ -0,0 +1,241 @@
+package pkg
+
+import (
+	"strings"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	"github.com/flanksource/duty/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"gocloud.dev/pubsub"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	AnnotationTraceparent = v1.Group + "/traceparent"
+	AnnotationTracestate  = v1.Group + "/tracestate"
+
+	// EnvTraceparent and EnvTracestate pass the trace context to workloads, as read by OpenTelemetry SDKs
+	EnvTraceparent = "TRACEPARENT"
+	EnvTracestate  = "TRACESTATE"
+)
+
+var tracer = otel.Tracer("github.com/flanksource/batch-runner")
+
+// traceContext is the W3C trace context, which is always propagated even when the global propagator is not
+var traceContext = propagation.TraceContext{}
+
+// messageCarrier reads the trace context from the metadata of a message, ignoring the case of keys as some
+// queues change the case of attributes and headers
+type messageCarrier map[string]string
+
+func (c messageCarrier) Get(key string) string {
+	for k, v := range c {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (c messageCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c messageCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// startReceiveSpan starts the span of a received message, as a child of the span of the producer if the
+// metadata of the message has a traceparent
+func startReceiveSpan(ctx context.Context, trigger string, msg *pubsub.Message) (context.Context, trace.Span) {
+	parent := traceContext.Extract(ctx, messageCarrier(msg.Metadata))
+	spanCtx, span := tracer.Start(parent, "receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.message.id", msg.LoggableID),
+			attribute.String("messaging.destination.name", trigger),
+			attribute.Int("messaging.message.body.size", len(msg.Body)),
+		))
+	return ctx.Wrap(spanCtx), span
+}
+
+// startSpan starts a child span of the span in ctx
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	spanCtx, span := tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx.Wrap(spanCtx), span
+}
+
+// endSpan records err on span before ending it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTraceContext returns the trace context of the span in ctx, or nil if there is no span
+func injectTraceContext(ctx context.Context) map[string]string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+	return carrier
+}
+
+// applyWorkloadTraceContext passes the trace context of ctx to the Pod or Job of a rendered message, and to the
+// Pods and Jobs in its resources. Applied workloads are only annotated, as the containers of a Pod and the pod
+// template of a Job are immutable.
+func applyWorkloadTraceContext(ctx context.Context, rendered *Rendered, mode v1.Mode) {
+	switch {
+	case rendered.Pod != nil && mode == v1.ModeApply:
+		applyTraceContext(ctx, rendered.Pod, nil)
+	case rendered.Pod != nil:
+		applyTraceContext(ctx, rendered.Pod, &rendered.Pod.Spec)
+	case rendered.Job != nil && mode == v1.ModeApply:
+		applyTraceContext(ctx, rendered.Job, nil)
+	case rendered.Job != nil:
+		applyTraceContext(ctx, rendered.Job, &rendered.Job.Spec.Template.Spec)
+		applyTraceContext(ctx, &rendered.Job.Spec.Template, &rendered.Job.Spec.Template.Spec)
+	}
+	for i := range rendered.Resources {
+		applyResourceTraceContext(ctx, &rendered.Resources[i], mode)
+	}
+}
+
+// applyResourceTraceContext is applyWorkloadTraceContext for an unstructured Pod or Job, other objects are
+// left as is
+func applyResourceTraceContext(ctx context.Context, obj *unstructured.Unstructured, mode v1.Mode) {
+	var spec []string
+	switch obj.GroupVersionKind().GroupKind() {
+	case schema.GroupKind{Kind: "Pod"}:
+		spec = []string{"spec"}
+	case schema.GroupKind{Group: "batch", Kind: "Job"}:
+		spec = []string{"spec", "template", "spec"}
+	default:
+		return
+	}
+	carrier := injectTraceContext(ctx)
+	if carrier == nil {
+		return
+	}
+	applyTraceContext(ctx, obj, nil)
+	if mode == v1.ModeApply {
+		return
+	}
+
+	if template, ok := nestedMap(obj.Object, "spec", "template"); ok && len(spec) > 1 {
+		applyTraceContext(ctx, &unstructured.Unstructured{Object: template}, nil)
+	}
+	podSpec, ok := nestedMap(obj.Object, spec...)
+	if !ok {
+		return
+	}
+	for _, key := range []string{"initContainers", "containers"} {
+		containers, _ := podSpec[key].([]any)
+		for _, c := range containers {
+			container, ok := c.(map[string]any)
+			if !ok {
+				continue
+			}
+			appendUnstructuredEnv(container, EnvTraceparent, carrier["traceparent"])
+			if state := carrier["tracestate"]; state != "" {
+				appendUnstructuredEnv(container, EnvTracestate, state)
+			}
+		}
+	}
+}
+
+// nestedMap returns the map at fields of obj without copying it, so that it can be changed in place
+func nestedMap(obj map[string]any, fields ...string) (map[string]any, bool) {
+	value, found, err := unstructured.NestedFieldNoCopy(obj, fields...)
+	if !found || err != nil {
+		return nil, false
+	}
+	m, ok := value.(map[string]any)
+	return m, ok
+}
+
+// applyTraceContext annotates obj with the trace context of ctx, and sets TRACEPARENT in each container of
+// spec if not nil so that a workload can continue the trace. Variables that are already set are kept.
+func applyTraceContext(ctx context.Context, obj metav1.Object, spec *corev1.PodSpec) {
+	carrier := injectTraceContext(ctx)
+	if carrier == nil {
+		return
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[AnnotationTraceparent] = carrier["traceparent"]
+	if state := carrier["tracestate"]; state != "" {
+		annotations[AnnotationTracestate] = state
+	}
+	obj.SetAnnotations(annotations)
+	if spec == nil {
+		return
+	}
+
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			containers[i].Env = appendEnv(containers[i].Env, EnvTraceparent, carrier["traceparent"])
+			if state := carrier["tracestate"]; state != "" {
+				containers[i].Env = appendEnv(containers[i].Env, EnvTracestate, state)
+			}
+		}
+	}
+}
+
+func appendEnv(env []corev1.EnvVar, name, value string) []corev1.EnvVar {
+	for _, e := range env {
+		if e.Name == name {
+			return env
+		}
+	}
+	return append(env, corev1.EnvVar{Name: name, Value: value})
+}
+
+// appendUnstructuredEnv is appendEnv for an unstructured container
+func appendUnstructuredEnv(container map[string]any, name, value string) {
+	env, _ := container["env"].([]any)
+	for _, e := range env {
+		if e, ok := e.(map[string]any); ok && e["name"] == name {
+			return
+		}
+	}
+	container["env"] = append(env, map[string]any{"name": name, "value": value})
+}
+
+// applyExecTraceContext sets TRACEPARENT in the environment of an exec script, unless it is already set
+func applyExecTraceContext(ctx context.Context, exec *v1.ExecAction) {
+	carrier := injectTraceContext(ctx)
+	if carrier == nil {
+		return
+	}
+	for _, name := range []string{EnvTraceparent, EnvTracestate} {
+		value := carrier[strings.ToLower(name)]
+		if value == "" {
+			continue
+		}
+		set := false
+		for _, e := range exec.EnvVars {
+			set = set || e.Name == name
+		}
+		if !set {
+			exec.EnvVars = append(exec.EnvVars, types.EnvVar{Name: name, ValueStatic: value})
+		}
+	}
+}
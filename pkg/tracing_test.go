@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	"github.com/flanksource/duty/context"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gocloud.dev/pubsub"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestTracePropagation(t *testing.T) {
+	RegisterTestingT(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	msg := &pubsub.Message{
+		LoggableID: "trace-1",
+		Body:       []byte(`{"name":"sync"}`),
+		// some queues change the case of attributes
+		Metadata: map[string]string{"Traceparent": "00-" + traceID + "-00f067aa0ba902b7-01"},
+	}
+
+	ctx, span := startReceiveSpan(context.New(), "jobs/trace", msg)
+
+	t.Run("continues the trace of the producer", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		config := &v1.Config{Exec: &v1.ExecAction{Script: "exit 0"}}
+		rendered, err := Render(ctx, config, msg, time.Now(), nil)
+		Expect(err).To(BeNil())
+		_, err = RunAction(ctx, nil, config, rendered, nil, nil)
+		Expect(err).To(BeNil())
+		span.End()
+
+		names := map[string]string{}
+		for _, s := range recorder.Ended() {
+			Expect(s.SpanContext().TraceID().String()).To(Equal(traceID))
+			names[s.Name()] = s.Parent().SpanID().String()
+		}
+		receive := span.SpanContext().SpanID().String()
+		Expect(names).To(HaveKeyWithValue("receive", "00f067aa0ba902b7"))
+		Expect(names).To(HaveKeyWithValue("decode", receive))
+		Expect(names).To(HaveKeyWithValue("template", receive))
+		Expect(names).To(HaveKeyWithValue("exec", receive))
+
+		Expect(rendered.Exec.EnvVars).To(HaveLen(1))
+		Expect(rendered.Exec.EnvVars[0].Name).To(Equal(EnvTraceparent))
+		Expect(rendered.Exec.EnvVars[0].ValueStatic).To(HavePrefix("00-" + traceID + "-"))
+	})
+
+	t.Run("passes the trace context to jobs", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		job := &batchv1.Job{}
+		job.Spec.Template.Spec.Containers = []corev1.Container{
+			{Name: "main"},
+			{Name: "sidecar", Env: []corev1.EnvVar{{Name: EnvTraceparent, Value: "kept"}}},
+		}
+		applyTraceContext(ctx, job, &job.Spec.Template.Spec)
+
+		traceparent := job.Annotations[AnnotationTraceparent]
+		Expect(strings.Split(traceparent, "-")[1]).To(Equal(traceID))
+		Expect(job.Spec.Template.Spec.Containers[0].Env).To(Equal([]corev1.EnvVar{{Name: EnvTraceparent, Value: traceparent}}))
+		Expect(job.Spec.Template.Spec.Containers[1].Env).To(Equal([]corev1.EnvVar{{Name: EnvTraceparent, Value: "kept"}}))
+	})
+
+	t.Run("only annotates applied workloads", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		job := &batchv1.Job{}
+		job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "main"}}
+		pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}}}
+		applyWorkloadTraceContext(ctx, &Rendered{Job: job}, v1.ModeApply)
+		applyWorkloadTraceContext(ctx, &Rendered{Pod: pod}, v1.ModeApply)
+
+		Expect(job.Annotations).To(HaveKey(AnnotationTraceparent))
+		Expect(job.Spec.Template.Annotations).To(BeEmpty())
+		Expect(job.Spec.Template.Spec.Containers[0].Env).To(BeEmpty())
+		Expect(pod.Annotations).To(HaveKey(AnnotationTraceparent))
+		Expect(pod.Spec.Containers[0].Env).To(BeEmpty())
+	})
+
+	t.Run("passes the trace context to pods and jobs in resources", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		job := manifest("batch/v1", "Job", "sync")
+		Expect(unstructured.SetNestedSlice(job.Object, []any{
+			map[string]any{"name": "main"},
+			map[string]any{"name": "sidecar", "env": []any{map[string]any{"name": EnvTraceparent, "value": "kept"}}},
+		}, "spec", "template", "spec", "containers")).To(Succeed())
+		applied := manifest("v1", "Pod", "applied")
+		Expect(unstructured.SetNestedSlice(applied.Object, []any{map[string]any{"name": "main"}}, "spec", "containers")).To(Succeed())
+		configMap := manifest("v1", "ConfigMap", "config")
+
+		applyWorkloadTraceContext(ctx, &Rendered{Resources: []unstructured.Unstructured{job, configMap}}, v1.ModeCreate)
+		applyWorkloadTraceContext(ctx, &Rendered{Resources: []unstructured.Unstructured{applied}}, v1.ModeApply)
+
+		traceparent := job.GetAnnotations()[AnnotationTraceparent]
+		Expect(strings.Split(traceparent, "-")[1]).To(Equal(traceID))
+		template, _, _ := unstructured.NestedStringMap(job.Object, "spec", "template", "metadata", "annotations")
+		Expect(template).To(HaveKeyWithValue(AnnotationTraceparent, traceparent))
+		containers, _, _ := unstructured.NestedSlice(job.Object, "spec", "template", "spec", "containers")
+		Expect(containers[0]).To(HaveKeyWithValue("env", []any{map[string]any{"name": EnvTraceparent, "value": traceparent}}))
+		Expect(containers[1]).To(HaveKeyWithValue("env", []any{map[string]any{"name": EnvTraceparent, "value": "kept"}}))
+		Expect(configMap.GetAnnotations()).To(BeEmpty())
+
+		Expect(applied.GetAnnotations()).To(HaveKey(AnnotationTraceparent))
+		containers, _, _ = unstructured.NestedSlice(applied.Object, "spec", "containers")
+		Expect(containers[0]).NotTo(HaveKey("env"))
+	})
+
+	t.Run("does nothing without a trace", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}}}
+		applyTraceContext(context.New(), pod, &pod.Spec)
+		Expect(pod.Annotations).To(BeNil())
+		Expect(pod.Spec.Containers[0].Env).To(BeEmpty())
+	})
+}
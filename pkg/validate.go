@@ -0,0 +1,274 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	v1 "github.com/flanksource/batch-runner/pkg/apis/batch/v1"
+	dutyps "github.com/flanksource/duty/pubsub"
+	"github.com/flanksource/gomplate/v3"
+	"github.com/google/cel-go/cel"
+	ottoParser "github.com/robertkrimen/otto/parser"
+	"sigs.k8s.io/yaml"
+)
+
+// templatedFields are the fields of a config that are templated with each message
+var templatedFields = []string{"pod", "job", "exec", "http", "publish.body", "publish.metadata", "sql", "helm", "git", "podExec", "resources", "artifactStore"}
+
+// untemplatedField matches the fields within templatedFields that are used as is, i.e. SQL queries
+var untemplatedField = regexp.MustCompile(`^sql\.statements\[\d+\]\.query$`)
+
+// ValidateConfigFiles checks each document in files for unknown fields, a single action and queue, and the
+// syntax of templates, returning every problem that is found
+func ValidateConfigFiles(files []string) ([]ConfigError, error) {
+	var errs []ConfigError
+	for _, file := range files {
+		docs, err := readConfigDocuments(file)
+		if err != nil {
+			return nil, err
+		}
+		for i := range docs {
+			errs = append(errs, validateConfig(&docs[i])...)
+		}
+	}
+	return errs, nil
+}
+
+func validateConfig(doc *configDocument) []ConfigError {
+	config, errs := parseConfig(doc)
+	if config == nil {
+		return errs
+	}
+
+	actions := configActions(config)
+	switch len(actions) {
+	case 0:
+		errs = append(errs, doc.errorf("", "%v", errNoAction))
+	case 1:
+	default:
+		errs = append(errs, doc.errorf(actions[1], "only one action can be set, found %s", strings.Join(actions, " and ")))
+	}
+
+	errs = append(errs, validateQueue(doc, "", config.QueueConfig, nil)...)
+	if config.Publish != nil {
+		errs = append(errs, validateQueue(doc, "publish", config.Publish.QueueConfig, &config.Publish.TopicConfig)...)
+	}
+
+	if delims, err := templateDelims(config.Template); err != nil {
+		errs = append(errs, doc.errorf("template", "%v", err))
+	} else {
+		errs = append(errs, validateTemplates(doc, templateSyntax(config.Template, delims))...)
+		if config.SQL != nil {
+			for i, statement := range config.SQL.Statements {
+				if strings.Contains(statement.Query, delims.Left) {
+					errs = append(errs, doc.errorf(fmt.Sprintf("sql.statements[%d].query", i), "queries are not templated, pass message values as args"))
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	return errs
+}
+
+// configActions returns the names of the actions that are set
+func configActions(config *v1.Config) []string {
+	var names []string
+	for _, action := range []struct {
+		name string
+		set  bool
+	}{
+		{"pod", config.Pod != nil},
+		{"job", config.Job != nil},
+		{"exec", config.Exec != nil},
+		{"http", config.HTTP != nil},
+		{"publish", config.Publish != nil},
+		{"sql", config.SQL != nil},
+		{"helm", config.Helm != nil},
+		{"git", config.Git != nil},
+		{"podExec", config.PodExec != nil},
+		{"resources", config.Resources != nil},
+	} {
+		if action.set {
+			names = append(names, action.name)
+		}
+	}
+	return names
+}
+
+// validateQueue checks that exactly one queue is configured with its required fields, path is the field the
+// queue is inlined in. When the queue is published to, topic is where messages are sent instead of the fields
+// that are only used to receive.
+func validateQueue(doc *configDocument, path string, queue dutyps.QueueConfig, topic *v1.TopicConfig) []ConfigError {
+	prefix := ""
+	if path != "" {
+		prefix = path + "."
+	}
+
+	var names []string
+	var errs []ConfigError
+	required := func(field string, missing bool) {
+		if missing {
+			errs = append(errs, doc.errorf(prefix+field, "is required"))
+		}
+	}
+	if queue.SQS != nil {
+		names = append(names, "sqs")
+		required("sqs.queue", queue.SQS.QueueArn == "")
+	}
+	if queue.PubSub != nil {
+		names = append(names, "pubsub")
+		required("pubsub.project_id", queue.PubSub.ProjectID == "")
+		if topic != nil {
+			required("topic", topic.Topic == "")
+		} else {
+			required("pubsub.subscription", queue.PubSub.Subscription == "")
+		}
+	}
+	if queue.RabbitMQ != nil {
+		names = append(names, "rabbitmq")
+		required("rabbitmq.host", queue.RabbitMQ.Host == "")
+		if topic != nil {
+			required("exchange", topic.Exchange == "")
+		} else {
+			required("rabbitmq.queue", queue.RabbitMQ.Queue == "")
+		}
+	}
+	if queue.Memory != nil {
+		names = append(names, "memory")
+		required("memory.queue", queue.Memory.QueueName == "")
+	}
+	if queue.Kafka != nil {
+		names = append(names, "kafka")
+		required("kafka.brokers", len(queue.Kafka.Brokers) == 0)
+		required("kafka.topic", queue.Kafka.Topic == "")
+	}
+	if queue.NATS != nil {
+		names = append(names, "nats")
+		required("nats.subject", queue.NATS.Subject == "")
+	}
+
+	switch len(names) {
+	case 0:
+		errs = append(errs, doc.errorf(path, "must specify one of sqs, pubsub, rabbitmq, memory, kafka or nats"))
+	case 1:
+	default:
+		errs = append(errs, doc.errorf(prefix+names[1], "only one queue can be set, found %s", strings.Join(names, " and ")))
+	}
+	return errs
+}
+
+// templateSyntax returns a function that parses the templates in a string without evaluating them. Go template
+// functions are not checked, as fields of the message can also be called as functions.
+func templateSyntax(config *v1.TemplateConfig, delims gomplate.Delims) func(val string) error {
+	engine := v1.TemplateEngineGo
+	if config != nil && config.Engine != "" {
+		engine = config.Engine
+	}
+
+	switch engine {
+	case v1.TemplateEngineCEL:
+		env, err := cel.NewEnv()
+		return func(val string) error {
+			if err != nil {
+				return err
+			}
+			return parseExpressions(val, delims, func(expr string) error {
+				_, issues := env.Parse(expr)
+				return issues.Err()
+			})
+		}
+	case v1.TemplateEngineJavascript:
+		return func(val string) error {
+			return parseExpressions(val, delims, func(expr string) error {
+				_, err := ottoParser.ParseFile(nil, "", expr, 0)
+				return err
+			})
+		}
+	default:
+		return func(val string) error {
+			tree := parse.New("")
+			tree.Mode = parse.SkipFuncCheck
+			_, err := tree.Parse(val, delims.Left, delims.Right, map[string]*parse.Tree{})
+			return err
+		}
+	}
+}
+
+// parseExpressions calls parse with each delimited expression in val, in the same way as exprTemplater
+func parseExpressions(val string, delims gomplate.Delims, parse func(expr string) error) error {
+	for {
+		start := strings.Index(val, delims.Left)
+		if start < 0 {
+			return nil
+		}
+		rest := val[start+len(delims.Left):]
+		end := closingDelim(rest, delims.Right)
+		if end < 0 {
+			return fmt.Errorf("unterminated expression, missing %q: %s", delims.Right, val[start:])
+		}
+		if err := parse(rest[:end]); err != nil {
+			return err
+		}
+		val = rest[end+len(delims.Right):]
+	}
+}
+
+// validateTemplates checks the syntax of every string in the templated fields of a document
+func validateTemplates(doc *configDocument, syntax func(val string) error) []ConfigError {
+	data, err := yaml.YAMLToJSON(doc.data)
+	if err != nil {
+		return nil
+	}
+	var values map[string]any
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil
+	}
+
+	var errs []ConfigError
+	for _, path := range templatedFields {
+		var value any = values
+		for _, field := range strings.Split(path, ".") {
+			if m, ok := value.(map[string]any); ok {
+				value = m[field]
+			} else {
+				value = nil
+			}
+		}
+		walkStrings(value, path, func(path, val string) {
+			if untemplatedField.MatchString(path) {
+				return
+			}
+			if err := syntax(val); err != nil {
+				errs = append(errs, doc.errorf(path, "%v", err))
+			}
+		})
+	}
+	return errs
+}
+
+// walkStrings calls fn with each string and map key of doc, and its path
+func walkStrings(doc any, path string, fn func(path, val string)) {
+	switch v := doc.(type) {
+	case string:
+		fn(path, v)
+	case []any:
+		for i, item := range v {
+			walkStrings(item, fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fn(path+"."+k, k)
+			walkStrings(v[k], path+"."+k, fn)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+	return path
+}
+
+func TestValidateConfigFiles(t *testing.T) {
+	validate := func(t *testing.T, content string) []string {
+		RegisterTestingT(t)
+		errs, err := ValidateConfigFiles([]string{writeConfig(t, content)})
+		Expect(err).To(BeNil())
+		var out []string
+		for _, e := range errs {
+			out = append(out, e.Path+":"+e.Message)
+			Expect(e.Line).To(BeNumerically(">", 0))
+		}
+		return out
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		Expect(validate(t, `
+exec:
+  script: "echo {{.name}}"
+sqs:
+  queue: test
+`)).To(BeEmpty())
+	})
+
+	t.Run("unknown fields with line numbers", func(t *testing.T) {
+		RegisterTestingT(t)
+		errs, err := ValidateConfigFiles([]string{writeConfig(t, `exec:
+  script: echo
+---
+jobs: {}
+exec:
+  script: echo
+sqs:
+  queu: test
+  queue: test
+`)})
+		Expect(err).To(BeNil())
+		Expect(errs).To(HaveLen(3))
+		Expect(errs[0].Line).To(Equal(1))
+		Expect(errs[0].Message).To(ContainSubstring("sqs, pubsub"))
+		Expect(errs[1].Line).To(Equal(4))
+		Expect(errs[1].Path).To(Equal("jobs"))
+		Expect(errs[2].Line).To(Equal(8))
+		Expect(errs[2].Path).To(Equal("sqs.queu"))
+
+		errs, err = ValidateConfigFiles([]string{writeConfig(t, `pod:
+  metadata:
+    labels:
+      app.kubernetes.io/name: a
+  spec:
+    containers:
+      - name: a
+        imagee: b
+sqs:
+  queu: x
+`)})
+		Expect(err).To(BeNil())
+		Expect(errs).To(HaveLen(3))
+		Expect(errs[0].Line).To(Equal(8))
+		Expect(errs[0].Path).To(Equal("pod.spec.containers[0].imagee"))
+		Expect(errs[1].Line).To(Equal(9))
+		Expect(errs[1].Path).To(Equal("sqs.queue"))
+		Expect(errs[2].Line).To(Equal(10))
+		Expect(errs[2].Path).To(Equal("sqs.queu"))
+	})
+
+	t.Run("exactly one action and queue", func(t *testing.T) {
+		Expect(validate(t, `
+pod: {}
+job: {}
+sqs:
+  queue: a
+memory:
+  queue: b
+`)).To(ConsistOf(
+			"job:only one action can be set, found pod and job",
+			"memory:only one queue can be set, found sqs and memory",
+		))
+		Expect(validate(t, `
+publish:
+  body: "{{.name}}"
+sqs:
+  queue: a
+`)).To(ConsistOf("publish:must specify one of sqs, pubsub, rabbitmq, memory, kafka or nats"))
+		Expect(validate(t, `
+publish:
+  pubsub:
+    project_id: p
+    subscription: ignored
+  rabbitmq:
+    host: rabbit
+    queue: ignored
+sqs:
+  queue: a
+`)).To(ConsistOf(
+			"publish.topic:is required",
+			"publish.exchange:is required",
+			"publish.rabbitmq:only one queue can be set, found pubsub and rabbitmq",
+		))
+	})
+
+	t.Run("template syntax", func(t *testing.T) {
+		Expect(validate(t, `
+exec:
+  script: "echo {{.name"
+sqs:
+  queue: a
+`)).To(ConsistOf(ContainSubstring("exec.script:template: :1: unclosed action")))
+
+		// functions are not checked, as message fields can be called
+		Expect(validate(t, `
+exec:
+  script: "echo {{ name | unknownFunction }}"
+sqs:
+  queue: a
+`)).To(BeEmpty())
+
+		Expect(validate(t, `
+template:
+  engine: cel
+http:
+  url: "https://example.com/$(name +)"
+  headers:
+    X-Id: "$(string(_id))"
+sqs:
+  queue: a
+`)).To(ConsistOf(ContainSubstring("http.url:")))
+
+		Expect(validate(t, `
+template:
+  engine: javascript
+exec:
+  script: "echo $(name.)"
+sqs:
+  queue: a
+`)).To(ConsistOf(ContainSubstring("exec.script:")))
+
+		Expect(validate(t, `
+template:
+  delims: ["[["]
+exec:
+  script: echo
+sqs:
+  queue: a
+`)).To(ConsistOf(ContainSubstring("template:template.delims")))
+
+		Expect(validate(t, `
+sql:
+  connection: "connection://default/{{.db}}"
+  statements:
+    - query: "UPDATE orders SET status = $1 WHERE id = {{.id}}"
+      args: ["{{.status}}"]
+sqs:
+  queue: a
+`)).To(ConsistOf("sql.statements[0].query:queries are not templated, pass message values as args"))
+	})
+
+	t.Run("yaml syntax errors", func(t *testing.T) {
+		RegisterTestingT(t)
+		errs, err := ValidateConfigFiles([]string{writeConfig(t, "exec:\n  script: echo\nsqs:\n  queue: a\n---\nexec:\n  script: [\n")})
+		Expect(err).To(BeNil())
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Line).To(Equal(7))
+	})
+}
+
+func TestParseConfigFiles(t *testing.T) {
+	RegisterTestingT(t)
+
+	configs, err := ParseConfigFiles([]string{writeConfig(t, "exec:\n  script: echo\nsqs:\n  queue: a\n---\nexec:\n  script: echo\nsqs:\n  queue: b\n")})
+	Expect(err).To(BeNil())
+	Expect(configs).To(HaveLen(2))
+	Expect(configs[1].SQS.QueueArn).To(Equal("b"))
+
+	t.Run("is lenient about unknown fields and case", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		configs, err := ParseConfigFiles([]string{writeConfig(t, "pod:\n  apiversion: v1\n  kind: Pod\nsqs:\n  queue: a\n  account: 12345\n")})
+		Expect(err).To(BeNil())
+		Expect(configs[0].Pod.APIVersion).To(Equal("v1"))
+		Expect(configs[0].SQS.QueueArn).To(Equal("a"))
+	})
+
+	t.Run("fails on invalid YAML", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		_, err := ParseConfigFiles([]string{writeConfig(t, "exec:\n  script: [\n")})
+		Expect(err).To(MatchError(ContainSubstring("config.yaml:")))
+	})
+}